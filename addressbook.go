@@ -0,0 +1,264 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	ethwallet "perun.network/go-perun/backend/ethereum/wallet"
+	"perun.network/go-perun/pkg/sortedkv"
+	"perun.network/go-perun/wire/net/simple"
+)
+
+// addrBookPrefix is the prefix of the address book table in the database.
+// It must not collide with the prefixes of the go-perun PersistRestorer.
+const addrBookPrefix = "prnm:AddrBook:"
+
+// maxStrangers is the maximal number of aliases that are kept for senders
+// that are not in the address book.
+const maxStrangers = 64
+
+type (
+	// Peer is an entry of the Client's address book.
+	// Alias and AvatarHash are announced by the peer itself, see
+	// Config.ExchangeAlias. Host and Port are empty for peers that we only
+	// have channels with.
+	Peer struct {
+		PerunID    *Address
+		Host       string
+		Port       int
+		Alias      string
+		AvatarHash []byte
+	}
+
+	// Peers is a slice of Peer's
+	Peers struct {
+		values []Peer
+	}
+
+	// peerEntry is the database representation of a Peer.
+	peerEntry struct {
+		Host       string   `json:"host"`
+		Port       int      `json:"port"`
+		Alias      string   `json:"alias,omitempty"`
+		AvatarHash [32]byte `json:"avatarHash"`
+	}
+
+	// addressBook maps PerunIDs to network addresses. It registers all
+	// entries in the dialer and stores them in the database once persistence
+	// is enabled. Peers are added with AddPeer or when we open a channel with
+	// them. The aliases of other senders are only kept in memory, at most
+	// maxStrangers of them.
+	addressBook struct {
+		mu        sync.Mutex
+		dialer    *simple.Dialer
+		peers     map[ethwallet.Address]peerEntry
+		strangers map[ethwallet.Address]peerEntry
+		db        sortedkv.Database // nil until persistence is enabled.
+	}
+)
+
+func newAddressBook(dialer *simple.Dialer) *addressBook {
+	return &addressBook{
+		dialer:    dialer,
+		peers:     make(map[ethwallet.Address]peerEntry),
+		strangers: make(map[ethwallet.Address]peerEntry),
+	}
+}
+
+// enablePersistence stores all current entries in `db` and then loads all
+// entries from it.
+func (b *addressBook) enablePersistence(db sortedkv.Database) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.db = sortedkv.NewTable(db, addrBookPrefix)
+	for addr, e := range b.peers {
+		if err := b.persist(addr, e); err != nil {
+			return err
+		}
+	}
+
+	it := b.db.NewIterator()
+	for it.Next() {
+		addr, err := NewAddressFromHex(it.Key())
+		if err != nil {
+			return errors.WithMessagef(err, "decoding address book key %s", it.Key())
+		}
+		var e peerEntry
+		if err := json.Unmarshal(it.ValueBytes(), &e); err != nil {
+			return errors.WithMessagef(err, "decoding address book entry of %s", it.Key())
+		}
+		b.set(addr.addr, e)
+	}
+	return errors.WithMessage(it.Close(), "closing iterator")
+}
+
+// put adds or updates the network address of `addr`.
+func (b *addressBook) put(addr ethwallet.Address, host string, port int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	e, ok := b.peers[addr]
+	if !ok {
+		e = b.strangers[addr]
+		delete(b.strangers, addr)
+	}
+	e.Host, e.Port = host, port
+	return b.update(addr, e)
+}
+
+// setAlias updates the alias and avatar hash of `addr`. The alias of a sender
+// that is not in the address book is only kept in memory until addChannelPeer
+// is called for it.
+func (b *addressBook) setAlias(addr ethwallet.Address, alias string, avatarHash [32]byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	e, ok := b.peers[addr]
+	if !ok {
+		if _, known := b.strangers[addr]; !known && len(b.strangers) >= maxStrangers {
+			for a := range b.strangers { // Evict an arbitrary stranger.
+				delete(b.strangers, a)
+				break
+			}
+		}
+		b.strangers[addr] = peerEntry{Alias: alias, AvatarHash: avatarHash}
+		return nil
+	}
+	e.Alias, e.AvatarHash = alias, avatarHash
+	return b.update(addr, e)
+}
+
+// addChannelPeer adds `addr` to the address book, together with the alias
+// that it announced, since we have a channel with it.
+func (b *addressBook) addChannelPeer(addr ethwallet.Address) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.peers[addr]; ok {
+		return nil
+	}
+	e := b.strangers[addr]
+	delete(b.strangers, addr)
+	return b.update(addr, e)
+}
+
+// alias returns the alias of `addr` or an empty string if it is unknown.
+func (b *addressBook) alias(addr ethwallet.Address) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if e, ok := b.peers[addr]; ok {
+		return e.Alias
+	}
+	return b.strangers[addr].Alias
+}
+
+// update sets the entry of `addr` and persists it if persistence is enabled.
+// The caller is expected to hold the mutex.
+func (b *addressBook) update(addr ethwallet.Address, e peerEntry) error {
+	b.set(addr, e)
+	if b.db == nil {
+		return nil
+	}
+	return b.persist(addr, e)
+}
+
+// remove removes the entry of `addr`.
+func (b *addressBook) remove(addr ethwallet.Address) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.peers[addr]; !ok {
+		return errors.New("unknown peer")
+	}
+	delete(b.peers, addr)
+	if b.db == nil {
+		return nil
+	}
+	return errors.WithMessage(b.db.Delete((&Address{addr}).ToHex()), "deleting address book entry")
+}
+
+// list returns all entries sorted by PerunID.
+func (b *addressBook) list() []Peer {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	peers := make([]Peer, 0, len(b.peers))
+	for addr, e := range b.peers {
+		peers = append(peers, e.toPeer(addr))
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].PerunID.ToHex() < peers[j].PerunID.ToHex() })
+	return peers
+}
+
+// set updates the in-memory entry and registers it in the dialer if it has a
+// network address. The caller is expected to hold the mutex.
+func (b *addressBook) set(addr ethwallet.Address, e peerEntry) {
+	b.peers[addr] = e
+	if e.Host != "" {
+		b.dialer.Register(&addr, fmt.Sprintf("%s:%d", e.Host, e.Port))
+	}
+}
+
+func (e peerEntry) toPeer(addr ethwallet.Address) Peer {
+	p := Peer{PerunID: &Address{addr}, Host: e.Host, Port: e.Port, Alias: e.Alias}
+	if e.AvatarHash != ([32]byte{}) {
+		p.AvatarHash = append([]byte(nil), e.AvatarHash[:]...)
+	}
+	return p
+}
+
+// persist writes the entry of `addr` to the database. The caller is expected
+// to hold the mutex.
+func (b *addressBook) persist(addr ethwallet.Address, e peerEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return errors.WithMessage(err, "encoding address book entry")
+	}
+	return errors.WithMessage(b.db.PutBytes((&Address{addr}).ToHex(), data), "writing address book entry")
+}
+
+// Length returns the length of the Peers slice.
+func (ps *Peers) Length() int {
+	return len(ps.values)
+}
+
+// Get returns the element at the given index.
+func (ps *Peers) Get(index int) (*Peer, error) {
+	if index < 0 || index >= len(ps.values) {
+		return nil, errors.New("get: index out of range")
+	}
+	p := ps.values[index]
+	return &p, nil
+}
+
+// AddPeer adds a new peer to the client or updates its network address. Must
+// be called before proposing a new channel with said peer.
+// If persistence is enabled, the peer is also stored in the database and
+// automatically added again by EnablePersistence after a restart.
+// Wraps go-perun/peer/net/Dialer.Register.
+// ref https://pkg.go.dev/perun.network/go-perun/peer/net?tab=doc#Dialer.Register
+func (c *Client) AddPeer(perunID *Address, host string, port int) error {
+	return c.addrBook.put(perunID.addr, host, port)
+}
+
+// RemovePeer removes a peer from the address book. The peer stays reachable
+// until the Client is restarted since the dialer does not support removing
+// addresses.
+func (c *Client) RemovePeer(perunID *Address) error {
+	return c.addrBook.remove(perunID.addr)
+}
+
+// GetPeers returns all peers of the address book.
+func (c *Client) GetPeers() *Peers {
+	return &Peers{c.addrBook.list()}
+}
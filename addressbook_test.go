@@ -0,0 +1,94 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	ethwallet "perun.network/go-perun/backend/ethereum/wallet"
+	"perun.network/go-perun/pkg/sortedkv/memorydb"
+	pkgtest "perun.network/go-perun/pkg/test"
+)
+
+func TestAddressBook_Aliases(t *testing.T) {
+	rng := pkgtest.Prng(t)
+	db := memorydb.NewDatabase()
+	b := newAddressBook(nil)
+	require.NoError(t, b.enablePersistence(db))
+	peer, stranger := newRandomAddress(rng), newRandomAddress(rng)
+
+	// Aliases of strangers are known but not persisted.
+	require.NoError(t, b.setAlias(*stranger, "Mallory", [32]byte{}))
+	assert.Equal(t, "Mallory", b.alias(*stranger))
+	assert.Empty(t, b.list())
+
+	// A channel peer is added with the alias it announced before.
+	require.NoError(t, b.setAlias(*peer, "Alice", [32]byte{1}))
+	require.NoError(t, b.addChannelPeer(*peer))
+	require.NoError(t, b.setAlias(*peer, "Alice2", [32]byte{2}))
+
+	restored := newAddressBook(nil)
+	require.NoError(t, restored.enablePersistence(db))
+	peers := restored.list()
+	require.Len(t, peers, 1)
+	assert.Equal(t, peer.String(), peers[0].PerunID.addr.String())
+	assert.Equal(t, "Alice2", peers[0].Alias)
+	assert.Empty(t, restored.alias(*stranger))
+}
+
+func TestAddressBook_MaxStrangers(t *testing.T) {
+	rng := pkgtest.Prng(t)
+	b := newAddressBook(nil)
+	for i := 0; i < 2*maxStrangers; i++ {
+		require.NoError(t, b.setAlias(*newRandomAddress(rng), "stranger", [32]byte{}))
+	}
+	assert.Len(t, b.strangers, maxStrangers)
+
+	// AddPeer keeps the alias of a stranger.
+	var addr ethwallet.Address
+	for addr = range b.strangers {
+		break
+	}
+	require.NoError(t, b.put(addr, "", 0))
+	assert.Len(t, b.strangers, maxStrangers-1)
+	assert.Equal(t, "stranger", b.alias(addr))
+}
+
+func TestMsgBus_HandleAlias(t *testing.T) {
+	rng := pkgtest.Prng(t)
+	bus := &msgBus{book: newAddressBook(nil)}
+	for _, tt := range []struct {
+		name  string
+		alias string
+		kept  bool
+	}{
+		{"short", "Alice", true},
+		{"longest", strings.Repeat("a", maxAliasLen), true},
+		{"too long", strings.Repeat("a", maxAliasLen+1), false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			sender := newRandomAddress(rng)
+			bus.handleAlias(sender, &aliasMsg{Alias: tt.alias})
+			alias := ""
+			if tt.kept {
+				alias = tt.alias
+			}
+			assert.Equal(t, alias, bus.book.alias(*sender))
+		})
+	}
+}
+
+func TestClient_ChannelPeerInAddressBook(t *testing.T) {
+	rng := pkgtest.Prng(t)
+	tc := newTestChannel(t, rng, &Config{})
+	peers := tc.bob.addrBook.list()
+	require.Len(t, peers, 1)
+	assert.True(t, tc.alice.Peers()[0].Equals(&peers[0].PerunID.addr))
+}
@@ -0,0 +1,105 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"context"
+	"io"
+	"time"
+
+	ethwallet "perun.network/go-perun/backend/ethereum/wallet"
+	"perun.network/go-perun/log"
+	perunio "perun.network/go-perun/pkg/io"
+	"perun.network/go-perun/wallet"
+	"perun.network/go-perun/wire"
+)
+
+// aliasTimeout is the time that sending an alias reply may take.
+const aliasTimeout = 10 * time.Second
+
+// maxAliasLen is the maximal length of an alias in bytes. Longer aliases of
+// peers are dropped.
+const maxAliasLen = 64
+
+func init() {
+	wire.RegisterExternalDecoder(msgAlias, func(r io.Reader) (wire.Msg, error) {
+		var m aliasMsg
+		return &m, m.Decode(r)
+	}, "Alias")
+}
+
+// aliasMsg announces the alias and avatar hash of its sender. Reply is set if
+// the message answers an aliasMsg of the recipient.
+type aliasMsg struct {
+	Alias      string
+	AvatarHash [32]byte
+	Reply      bool
+}
+
+// Type returns msgAlias.
+func (aliasMsg) Type() wire.Type {
+	return msgAlias
+}
+
+// Encode encodes the aliasMsg into an io.Writer.
+func (m aliasMsg) Encode(w io.Writer) error {
+	return perunio.Encode(w, m.Alias, m.AvatarHash, m.Reply)
+}
+
+// Decode decodes an aliasMsg from an io.Reader.
+func (m *aliasMsg) Decode(r io.Reader) error {
+	return perunio.Decode(r, &m.Alias, &m.AvatarHash, &m.Reply)
+}
+
+// handleAlias stores the alias of `peer` in the address book.
+func (b *msgBus) handleAlias(peer wire.Address, m *aliasMsg) {
+	if len(m.Alias) > maxAliasLen {
+		log.WithField("peer", peer).Warnf("Dropped alias of %d bytes", len(m.Alias))
+		return
+	}
+	if err := b.book.setAlias(*peer.(*ethwallet.Address), m.Alias, m.AvatarHash); err != nil {
+		log.WithError(err).Warn("Storing alias failed")
+	}
+}
+
+func (b *msgBus) replyAlias(peer wire.Address, reply bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), aliasTimeout)
+	defer cancel()
+	if err := b.sendAlias(ctx, peer, reply); err != nil {
+		log.WithError(err).Warn("Sending alias failed")
+	}
+}
+
+func (b *msgBus) sendAlias(ctx context.Context, peer wire.Address, reply bool) error {
+	m := b.alias
+	m.Reply = reply
+	return b.send(ctx, peer, &m)
+}
+
+// greet marks `peer` as greeted and returns whether it was not greeted before.
+func (b *msgBus) greet(peer wire.Address) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	key := wallet.Key(peer)
+	if b.greeted[key] {
+		return false
+	}
+	b.greeted[key] = true
+	return true
+}
+
+// GetPeerAlias returns the alias of the participant with index `idx` or an
+// empty string if it is unknown.
+func (c *PaymentChannel) GetPeerAlias(idx int) string {
+	if idx == int(c.ch.Idx()) {
+		return c.c.cfg.Alias
+	}
+	peers := c.ch.Peers()
+	if idx < 0 || idx >= len(peers) {
+		return ""
+	}
+	return c.c.addrBook.alias(*peers[idx].(*ethwallet.Address))
+}
@@ -25,7 +25,7 @@ import static org.hamcrest.Matchers.is;
 import static org.hamcrest.Matchers.notNullValue;
 
 @RunWith(AndroidJUnit4.class)
-public class PrnmTest implements prnm.NewChannelCallback, prnm.ProposalHandler, prnm.UpdateHandler, prnm.ConcludedEventHandler {
+public class PrnmTest implements prnm.NewChannelCallback, prnm.ProposalHandler, prnm.UpdateHandler, prnm.DisputeHandler {
 
     /**
      * This class contains four entry points for the test-runner; two per peer and test case.
@@ -40,7 +40,6 @@ public class PrnmTest implements prnm.NewChannelCallback, prnm.ProposalHandler,
     Setup setup;
 
     AtomicInteger receivedTx = new AtomicInteger(0);
-    AtomicBoolean watcherStopped = new AtomicBoolean(false);
     // handleConcluded returned
     AtomicBoolean concluded = new AtomicBoolean(false);
     AtomicReference<PaymentChannel> ch = new AtomicReference<PaymentChannel>(null);
@@ -151,6 +150,7 @@ public class PrnmTest implements prnm.NewChannelCallback, prnm.ProposalHandler,
 
         client.addPeer(Setup.Addresses[1-s.Index], Setup.Hosts[1-s.Index], Setup.Ports[1-s.Index]);
         client.onNewChannel(this);
+        client.enableWatchers(this);
         new Thread(() -> {
             client.handle(this, this);
         }).start();
@@ -182,7 +182,7 @@ public class PrnmTest implements prnm.NewChannelCallback, prnm.ProposalHandler,
             if (wait)
                 await().atMost(20, TimeUnit.SECONDS).untilAtomic(receivedTx, is(i +1));
             log("Sending TX…");
-            ch.get().send(ctx, eth(amount));
+            ch.get().send(ctx, 1 - setup.Index, eth(amount));
             log("TX sent.");
             if (!wait)
                 await().atMost(20, TimeUnit.SECONDS).untilAtomic(receivedTx, is(i +1));
@@ -202,21 +202,13 @@ public class PrnmTest implements prnm.NewChannelCallback, prnm.ProposalHandler,
 
         await().atMost(20, TimeUnit.SECONDS).untilAtomic(concluded, is(true));
         ch.get().close();
-        await().atMost(20, TimeUnit.SECONDS).untilAtomic(watcherStopped, is(true));
+        await().atMost(20, TimeUnit.SECONDS).until(() -> ch.get().getWatcherHealth() == null);
     }
 
     @Override
     public void onNew(PaymentChannel channel) {
         log("onNewChannel");
         ch.set(channel);
-        new Thread(() -> {
-            Assertions.assertDoesNotThrow(() -> {
-                log("Watcher started");
-                channel.watch(this);
-                log("Watcher stopped");
-                watcherStopped.set(true);
-            });
-        }).start();
         log("onNewChannel done");
     }
 
@@ -239,6 +231,21 @@ public class PrnmTest implements prnm.NewChannelCallback, prnm.ProposalHandler,
         });
     }
 
+    @Override
+    public void handlePaymentRequest(PaymentRequest request) {
+        log("handlePaymentRequest: ignored");
+    }
+
+    @Override
+    public void handleRegistered(byte[] id, long version, long timeout) {
+        log("handleRegistered: version " + version);
+    }
+
+    @Override
+    public void handleProgressed(byte[] id, long version, long timeout) {
+        log("handleProgressed: version " + version);
+    }
+
     @Override
     public void handleConcluded(byte[] id) {
         if (this.setup.Index == 1) {
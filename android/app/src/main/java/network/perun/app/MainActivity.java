@@ -6,15 +6,14 @@
 package network.perun.app;
 
 import android.app.Activity;
+import android.app.AlertDialog;
 import android.os.Bundle;
 import android.util.Log;
 import android.widget.TextView;
 
 import java.util.Arrays;
-import java.util.HashMap;
-import java.util.Map;
-import java.util.concurrent.ConcurrentHashMap;
-import java.nio.ByteBuffer;
+import java.util.concurrent.CountDownLatch;
+import java.util.concurrent.atomic.AtomicBoolean;
 import java.math.BigInteger;
 
 import prnm.*;
@@ -54,7 +53,7 @@ public class MainActivity extends Activity {
             Address assetholder = new Address("0xb051EAD0C6CC2f568166F8fEC4f07511B88678bA");
             // We will be listening on 127.0.0.1:5750 for new channel proposals with the alias "Alice".
             Config cfg = new Config("Alice", onChain, adjudicator, assetholder, ethUrl, "127.0.0.1", 5750);
-            node = new Node(cfg, wallet);
+            node = new Node(this, cfg, wallet);
             Address bob = new Address("0xA298Fc05bccff341f340a11FffA30567a00e651f");
             // Create the initial balances of the channel, we start with 2000 and bob with 1000.
             node.addPeer(bob, "10.0.2.2", 5750);
@@ -62,10 +61,11 @@ public class MainActivity extends Activity {
             // (Optional) Enable the persistence and reconnect to peers:
             //
             // EnablePersistence attempts to load the database from the given path or creates
-            // a new one. It then retrieves all channels from the database.
+            // a new one. It then retrieves all channels and peers from the database.
             node.enablePersistence(dbPath);
             // Restore tries to reestablish connections to all previously connected peers.
-            // It needs to be called only once, but all peers need to be added beforehand.
+            // It needs to be called only once. Peers that were added in an earlier run are
+            // restored from the database.
             node.restore();
 
             // (Optional) Propose a channel to bob:
@@ -88,18 +88,24 @@ public class MainActivity extends Activity {
     }
 }
 
-class Node implements prnm.NewChannelCallback, prnm.ProposalHandler, prnm.UpdateHandler, prnm.ConcludedEventHandler {
+class Node implements prnm.NewChannelCallback, prnm.ProposalHandler, prnm.UpdateHandler, prnm.DisputeHandler {
+    // Payment requests above 0.1 ETH are declined without asking the user.
+    static final BigInt MAX_REQUEST = new BigInt("100000000000000000", 10);
+
     public Client client;
-    // Since java uses _pointer comparison_ for byte[] keys in a map, we need to wrap it in ByteBuffer.
-    public Map<ByteBuffer, PaymentChannel> chs = new ConcurrentHashMap<ByteBuffer, PaymentChannel>();
+    // Shows the confirmation dialogs.
+    private final Activity activity;
 
-    public Node(Config cfg, Wallet wallet) throws Exception {
+    public Node(Activity activity, Config cfg, Wallet wallet) throws Exception {
+        this.activity = activity;
         // Possibly has to deploy contracts, so give it some extra time.
         Context ctx = Prnm.contextWithTimeout(600);
         try {
             client = new Client(ctx, cfg, wallet);
             // Set the handler for new channels.
             client.onNewChannel(this);
+            // Let the client watch all channels and restart failed watchers.
+            client.enableWatchers(this);
         } finally {
             ctx.cancel();
         }
@@ -130,8 +136,9 @@ class Node implements prnm.NewChannelCallback, prnm.ProposalHandler, prnm.Update
         }
     }
 
-    public void addPeer(prnm.Address peer, String ip, int port) {
-        // This is safe to call more than once.
+    public void addPeer(prnm.Address peer, String ip, int port) throws Exception {
+        // This is safe to call more than once. Once persistence is enabled,
+        // the peer is also stored in the database.
         client.addPeer(peer, ip, port);
     }
 
@@ -140,10 +147,8 @@ class Node implements prnm.NewChannelCallback, prnm.ProposalHandler, prnm.Update
         Context ctx = Prnm.contextWithTimeout(600);
         try {
             byte[] id = client.proposeChannel(ctx, peer, 60, initBals).getParams().getID();
-            // Retrieve the channel from chs which was inserted by accept.
-            PaymentChannel ch = chs.get(ByteBuffer.wrap(id));
-            if (ch == null)
-                throw new Exception(String.format("propose: channel not found id=%s", new BigInteger(1, id).toString(16)));
+            // The client keeps track of all open channels.
+            PaymentChannel ch = client.getChannel(id);
             Log.i("prnm", "Proposal to peer " + peer.toHex() + " successful, id: " + ch.getParams().getID());
             return id;
         } finally {
@@ -159,10 +164,8 @@ class Node implements prnm.NewChannelCallback, prnm.ProposalHandler, prnm.Update
             BigInts bals = proposal.getInitBals();
             Log.i("prnm", String.format("Channel proposal (id=%s, bals=[%d,%d])", proposal.getPeer().toHex(), bals.get(0).toInt64(), bals.get(1).toInt64()));
             byte[] id = responder.accept(ctx).getParams().getID();
-            // Retrieve the channel from chs which was inserted by accept.
-            PaymentChannel ch = chs.get(ByteBuffer.wrap(id));
-            if (ch == null)
-                throw new Exception(String.format("accept: channel not found id=%s", new BigInteger(1, id).toString(16)));
+            // The client keeps track of all open channels.
+            PaymentChannel ch = client.getChannel(id);
              Log.i("prnm", "Accepted new channel proposal (id=" + ch.getParams().getID());
         } catch (Exception e) {
             Log.e("prnm", e.toString());
@@ -171,27 +174,22 @@ class Node implements prnm.NewChannelCallback, prnm.ProposalHandler, prnm.Update
         }
     }
 
-    // Handles all new channels by calling `watch` on them.
+    // Logs all new channels. They are watched by the client.
     @Override
     public void onNew(PaymentChannel channel) {
         byte[] id = channel.getParams().getID();
-        if (chs.containsKey(ByteBuffer.wrap(id))) {
-            Log.w("prnm", "Overriding Channel " + new BigInteger(1, id).toString(16));
-        } else {
-            Log.i("prnm", "New channel " + new BigInteger(1, id).toString(16));
-        }
-        chs.put(ByteBuffer.wrap(id), channel);
+        Log.i("prnm", "New channel " + new BigInteger(1, id).toString(16));
+    }
 
-        // Start a new thread for watching the channel.
-        new Thread(() -> {
-            try {
-                Log.d("channel", "Starting watching");
-                channel.watch(this);
-                Log.d("channel", "Stopped watching");
-            }  catch (Exception e) {
-                Log.e("channel", "Error watching:" + e.toString());
-            }
-        }).start();
+    // Warns about disputes. The watcher refutes old states by itself.
+    @Override
+    public void handleRegistered(byte[] id, long version, long timeout) {
+        Log.w("channel", String.format("Dispute in channel %s (version=%d, timeout=%d)", new BigInteger(1, id).toString(16), version, timeout));
+    }
+
+    @Override
+    public void handleProgressed(byte[] id, long version, long timeout) {
+        Log.w("channel", String.format("Channel %s progressed (version=%d, timeout=%d)", new BigInteger(1, id).toString(16), version, timeout));
     }
 
     // Handles all channel updates by accepting them.
@@ -211,20 +209,63 @@ class Node implements prnm.NewChannelCallback, prnm.ProposalHandler, prnm.Update
         }
     }
 
+    // Pays the requested amount only if it is below MAX_REQUEST and the user
+    // confirmed the request. Blocks until the user decided, further requests
+    // of the peer are dropped meanwhile.
+    @Override
+    public void handlePaymentRequest(PaymentRequest request) {
+        String desc = String.format("Pay %s Wei (memo=%s)?", request.getAmount().toString(), request.getMemo());
+        Log.i("channel", "Payment request: " + desc);
+        if (request.getAmount().cmp(MAX_REQUEST) > 0) {
+            Log.w("channel", "Declined payment request above the limit");
+            return;
+        }
+        Context ctx = null;
+        try {
+            if (!confirm("Payment request", desc)) {
+                Log.i("channel", "User declined payment request");
+                return;
+            }
+            ctx = Prnm.contextWithTimeout(5);
+            request.approve(ctx);
+        } catch (Exception e) {
+            Log.e("channel", e.toString());
+        } finally {
+            if (ctx != null) {
+                ctx.cancel();
+            }
+        }
+    }
+
+    // Asks the user to confirm `message` and waits for the answer.
+    private boolean confirm(String title, String message) throws InterruptedException {
+        CountDownLatch answered = new CountDownLatch(1);
+        AtomicBoolean confirmed = new AtomicBoolean(false);
+        activity.runOnUiThread(() -> new AlertDialog.Builder(activity)
+            .setTitle(title)
+            .setMessage(message)
+            .setPositiveButton("Pay", (dialog, which) -> {
+                confirmed.set(true);
+                answered.countDown();
+            })
+            .setNegativeButton("Decline", (dialog, which) -> answered.countDown())
+            .setOnCancelListener(dialog -> answered.countDown())
+            .show());
+        answered.await();
+        return confirmed.get();
+    }
+
     // Handles all channel conclusion events on the Adjudicator.
     @Override
     public void handleConcluded(byte[] id) {
         Log.i("channel", "Received concluded event for channel " + new BigInteger(1, id).toString(16));
         Context ctx = Prnm.contextWithTimeout(30);
         try {
-            PaymentChannel ch = chs.get(ByteBuffer.wrap(id));
-            if (ch == null) {
-                // If we initiated the channel closing, then the channel should
-                // already be removed and we return.
-                return;
-            }
+            // If we initiated the channel closing, then the channel should
+            // already be closed and getChannel throws.
+            PaymentChannel ch = client.getChannel(id);
             ch.settle(ctx, true);
-            chs.remove(ByteBuffer.wrap(id));
+            ch.close();
             Log.i("channel", "Settled channel " + new BigInteger(1, id).toString(16));
         } catch (Exception e) {
             Log.e("channel", e.toString());
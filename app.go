@@ -0,0 +1,127 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	ethwallet "perun.network/go-perun/backend/ethereum/wallet"
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/wallet"
+)
+
+// appRegistry holds the apps that the Client accepts in channel proposals.
+type appRegistry struct {
+	mu   sync.RWMutex
+	apps map[wallet.AddrKey]channel.App
+}
+
+func newAppRegistry() *appRegistry {
+	return &appRegistry{apps: make(map[wallet.AddrKey]channel.App)}
+}
+
+func (r *appRegistry) register(app channel.App) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.apps[wallet.Key(app.Def())] = app
+}
+
+// get returns the app with definition `def` or an error if it is not
+// registered.
+func (r *appRegistry) get(def wallet.Address) (channel.App, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	app, ok := r.apps[wallet.Key(def)]
+	if !ok {
+		return nil, errors.Errorf("app %v is not registered", def)
+	}
+	return app, nil
+}
+
+// RegisterApp registers `app` at the Client so that channels with it can be
+// proposed and are accepted. The app is also registered globally in go-perun
+// so that its data can be decoded. The app's definition must be the on-chain
+// address of its contract.
+// RegisterApp can not be called from Java, apps have to be implemented in Go.
+// ref https://pkg.go.dev/perun.network/go-perun/channel?tab=doc#App
+func (c *Client) RegisterApp(app channel.App) {
+	channel.RegisterApp(app)
+	c.apps.register(app)
+}
+
+// ProposeAppChannel proposes a new channel that runs the registered app with
+// definition `appDef`. `initData` is the encoded initial app data and is
+// decoded by the app. It otherwise behaves like ProposeMultiAssetChannel.
+func (c *Client) ProposeAppChannel(
+	ctx *Context,
+	perunID *Address,
+	challengeDuration int64,
+	initialAlloc *Allocation,
+	appDef *Address,
+	initData []byte,
+) (*PaymentChannel, error) {
+	app, err := c.apps.get(&appDef.addr)
+	if err != nil {
+		return nil, err
+	}
+	data, err := decodeAppData(app, initData)
+	if err != nil {
+		return nil, err
+	}
+	return c.proposeLedgerChannel(ctx, perunID, challengeDuration, initialAlloc, app, data)
+}
+
+// UpdateAppState proposes a new state with the encoded app data `data`. If
+// `alloc` is not nil, the balances are also set to the ones of `alloc`. The
+// app decides whether the transition is valid.
+func (c *PaymentChannel) UpdateAppState(ctx *Context, data []byte, alloc *Allocation) error {
+	app := c.ch.Params().App
+	if channel.IsNoApp(app) {
+		return errors.New("channel has no app")
+	}
+	appData, err := decodeAppData(app, data)
+	if err != nil {
+		return err
+	}
+
+	return c.ch.UpdateBy(ctx.ctx, func(state *channel.State) error {
+		if alloc != nil {
+			if err := channel.AssetsAssertEqual(state.Assets, alloc.assets); err != nil {
+				return errors.WithMessage(err, "comparing assets")
+			}
+			state.Balances = channel.Balances(alloc.balances).Clone()
+		}
+		state.Data = appData
+		return nil
+	})
+}
+
+// GetAppDef returns the definition of the channel's app or nil if the channel
+// is a plain payment channel.
+func (p *Params) GetAppDef() *Address {
+	if channel.IsNoApp(p.params.App) {
+		return nil
+	}
+	return &Address{*p.params.App.Def().(*ethwallet.Address)}
+}
+
+// GetAppData returns the encoded app data. It is empty for payment channels.
+func (s *State) GetAppData() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := s.s.Data.Encode(&buf); err != nil {
+		return nil, errors.WithMessage(err, "encoding app data")
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeAppData decodes `data` with the given app.
+func decodeAppData(app channel.App, data []byte) (channel.Data, error) {
+	d, err := app.DecodeData(bytes.NewReader(data))
+	return d, errors.WithMessage(err, "decoding app data")
+}
@@ -0,0 +1,212 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/pkg/errors"
+
+	ethchannel "perun.network/go-perun/backend/ethereum/channel"
+	ethwallet "perun.network/go-perun/backend/ethereum/wallet"
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/log"
+)
+
+// assetRegistry is a channel.Funder that holds one Depositor per registered
+// asset. Assets can be registered while the Client is running.
+type assetRegistry struct {
+	mu     sync.RWMutex
+	cb     ethchannel.ContractBackend
+	acc    accounts.Account
+	assets []ethwallet.Address // AssetHolders in registration order.
+	deps   map[ethchannel.Asset]ethchannel.Depositor
+	funder *ethchannel.Funder
+	// beforeFund holds functions that are called before a channel is funded,
+	// keyed by our participant in the channel.
+	beforeFund map[ethwallet.Address]func() error
+}
+
+func newAssetRegistry(cb ethchannel.ContractBackend, acc accounts.Account) *assetRegistry {
+	r := &assetRegistry{
+		cb:         cb,
+		acc:        acc,
+		deps:       make(map[ethchannel.Asset]ethchannel.Depositor),
+		beforeFund: make(map[ethwallet.Address]func() error),
+	}
+	r.funder = ethchannel.NewFunder(cb, nil, nil)
+	return r
+}
+
+// Fund implements the channel.Funder interface by forwarding the request to
+// the ethchannel.Funder that knows all currently registered assets. A function
+// that was registered with onFunding for the channel is called first.
+func (r *assetRegistry) Fund(ctx context.Context, req channel.FundingReq) error {
+	r.mu.Lock()
+	funder := r.funder
+	part, _ := req.Params.Parts[req.Idx].(*ethwallet.Address)
+	var before func() error
+	if part != nil {
+		before = r.beforeFund[*part]
+		delete(r.beforeFund, *part)
+	}
+	r.mu.Unlock()
+
+	if before != nil {
+		if err := before(); err != nil {
+			return errors.WithMessage(err, "preparing funding")
+		}
+	}
+	return funder.Fund(withTxPurpose(ctx, TxDeposit, req.Params.ID()), req)
+}
+
+// onFunding registers `f` to be called before the channel in which `part` is
+// our participant gets funded. For proposed channels, this happens after the
+// peer accepted the proposal. The returned function unregisters `f` if it was
+// not called yet.
+func (r *assetRegistry) onFunding(part ethwallet.Address, f func() error) (cancel func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.beforeFund[part] = f
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		delete(r.beforeFund, part)
+	}
+}
+
+// register adds the AssetHolder `asset` with Depositor `dep` to the registry.
+// The ethchannel.Funder does not synchronize access to its depositors, so a
+// new one is created instead of modifying the existing one.
+func (r *assetRegistry) register(asset ethwallet.Address, dep ethchannel.Depositor) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.deps[asset]; !ok {
+		r.assets = append(r.assets, asset)
+	}
+	r.deps[asset] = dep
+	accs := make(map[ethchannel.Asset]accounts.Account, len(r.deps))
+	deps := make(map[ethchannel.Asset]ethchannel.Depositor, len(r.deps))
+	for a, d := range r.deps {
+		accs[a] = r.acc
+		deps[a] = d
+	}
+	r.funder = ethchannel.NewFunder(r.cb, accs, deps)
+}
+
+// isRegistered returns whether `asset` is a registered AssetHolder.
+func (r *assetRegistry) isRegistered(asset channel.Asset) bool {
+	a, ok := asset.(*ethwallet.Address)
+	if !ok {
+		return false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, ok = r.deps[*a]
+	return ok
+}
+
+// list returns the AssetHolders of all registered assets.
+func (r *assetRegistry) list() []ethwallet.Address {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]ethwallet.Address(nil), r.assets...)
+}
+
+// depositor returns the Depositor of the AssetHolder `asset`.
+func (r *assetRegistry) depositor(asset ethwallet.Address) (ethchannel.Depositor, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	dep, ok := r.deps[asset]
+	return dep, ok
+}
+
+// RegisterTokenAsset registers an ERC20 `token` as asset that can be used in
+// channels. If `assetHolder` is nil, an ERC20 AssetHolder for the token is
+// deployed, otherwise the contract at `assetHolder` is validated.
+// Returns the address of the AssetHolder which identifies the asset in
+// channel proposals and states.
+func (c *Client) RegisterTokenAsset(ctx *Context, token, assetHolder *Address) (*Address, error) {
+	assetHolder, err := setupERC20AssetHolder(withTxPurpose(ctx.ctx, TxDeploy, channel.ID{}), c.cb, c.assets.acc, c.cfg.Adjudicator, token, assetHolder)
+	if err != nil {
+		return nil, err
+	}
+	c.assets.register(assetHolder.addr, &ethchannel.ERC20Depositor{Token: common.Address(token.addr)})
+	log.WithFields(log.Fields{"token": token.ToHex(), "assetHolder": assetHolder.ToHex()}).Debug("Registered token asset")
+	return assetHolder, nil
+}
+
+// GetAssets returns the AssetHolders of all assets that can be used in
+// channels. The ETH AssetHolder of the Config is always the first one.
+func (c *Client) GetAssets() *Addresses {
+	return &Addresses{values: c.assets.list()}
+}
+
+// setupERC20AssetHolder deploys an ERC20 AssetHolder for `token` if
+// `assetHolder` is nil. Otherwise it validates the given `assetHolder`.
+func setupERC20AssetHolder(ctx context.Context, cb ethchannel.ContractBackend, deployer accounts.Account, adjudicator, token, assetHolder *Address) (*Address, error) {
+	adj, tok := common.Address(adjudicator.addr), common.Address(token.addr)
+	if assetHolder == nil {
+		addr, err := ethchannel.DeployERC20Assetholder(ctx, cb, adj, tok, deployer)
+		if err != nil {
+			return nil, errors.WithMessage(err, "deploying erc20 assetHolder")
+		}
+		return &Address{ethwallet.Address(addr)}, nil
+	}
+	err := ethchannel.ValidateAssetHolderERC20(ctx, cb, common.Address(assetHolder.addr), adj, tok)
+	return assetHolder, validateContract(err, "ERC20 AssetHolder", assetHolder)
+}
+
+// Allocation is the distribution of funds in a channel. It contains one
+// balance for every participant and asset.
+// ref https://pkg.go.dev/perun.network/go-perun/channel?tab=doc#Allocation
+type Allocation struct {
+	assets   []channel.Asset
+	balances [][]channel.Bal
+}
+
+// NewAllocation creates an empty Allocation. Add assets with AddAsset.
+func NewAllocation() *Allocation {
+	return &Allocation{}
+}
+
+// AddAsset adds the asset with the given AssetHolder and the balances of all
+// participants for it.
+func (a *Allocation) AddAsset(assetHolder *Address, bals *BigInts) error {
+	if len(a.balances) != 0 && len(a.balances[0]) != len(bals.values) {
+		return errors.New("number of balances does not match the other assets")
+	}
+	addr := assetHolder.addr
+	a.assets = append(a.assets, &addr)
+	a.balances = append(a.balances, bals.values)
+	return nil
+}
+
+// GetNumAssets returns the number of assets.
+func (a *Allocation) GetNumAssets() int {
+	return len(a.assets)
+}
+
+// GetAsset returns the AssetHolder of the asset at the given index.
+func (a *Allocation) GetAsset(index int) (*Address, error) {
+	if index < 0 || index >= len(a.assets) {
+		return nil, errors.New("get: index out of range")
+	}
+	return &Address{*a.assets[index].(*ethwallet.Address)}, nil
+}
+
+// GetBalances returns the balances of all participants for the asset at the
+// given index.
+func (a *Allocation) GetBalances(index int) (*BigInts, error) {
+	if index < 0 || index >= len(a.balances) {
+		return nil, errors.New("get: index out of range")
+	}
+	return &BigInts{values: a.balances[index]}, nil
+}
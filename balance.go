@@ -0,0 +1,117 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"context"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/pkg/errors"
+
+	"perun.network/go-perun/log"
+)
+
+// balancePollInterval is the time between two balance queries if the ETH node
+// does not support new-head subscriptions.
+const balancePollInterval = 15 * time.Second
+
+type (
+	// OnChainBalanceHandler is notified about changes of an on-chain balance,
+	// see Client.SubscribeOnChainBalance.
+	OnChainBalanceHandler interface {
+		OnBalanceChanged(address *Address, balance *BigInt)
+	}
+
+	// BalanceSubscription is a subscription to an on-chain balance.
+	BalanceSubscription struct {
+		cancel context.CancelFunc
+	}
+)
+
+// SubscribeOnChainBalance calls `h` whenever the on-chain balance of
+// `address` in Wei changes. The balance is queried once per new block, or
+// periodically if the ETH node does not support subscriptions, e.g. for HTTP
+// URLs. `h` is not called for the initial balance, use OnChainBalance for it.
+// The subscription ends when it is closed or the Client is closed.
+func (c *Client) SubscribeOnChainBalance(address *Address, h OnChainBalanceHandler) (*BalanceSubscription, error) {
+	ctx, cancel := context.WithCancel(c.ctx)
+	addr := common.Address(address.addr)
+	bal, err := c.ethClient.BalanceAt(ctx, addr, nil)
+	if err != nil {
+		cancel()
+		return nil, errors.Wrap(err, "querying balance")
+	}
+
+	heads := make(chan *types.Header, 1)
+	sub, err := c.ethClient.SubscribeNewHead(ctx, heads)
+	if err != nil {
+		log.WithError(err).Debug("Subscribing to new heads failed, polling balance")
+		sub = nil
+	}
+	go c.watchBalance(ctx, address, bal, heads, sub, h)
+	return &BalanceSubscription{cancel}, nil
+}
+
+// watchBalance calls `h` whenever the balance of `address` differs from the
+// last one. It falls back to polling if `sub` is nil or fails.
+func (c *Client) watchBalance(ctx context.Context, address *Address, last *big.Int, heads <-chan *types.Header, sub ethereum.Subscription, h OnChainBalanceHandler) {
+	var (
+		ticker *time.Ticker
+		poll   <-chan time.Time
+		subErr <-chan error
+	)
+	startPolling := func() {
+		ticker = time.NewTicker(balancePollInterval)
+		poll = ticker.C
+	}
+	defer func() {
+		if ticker != nil {
+			ticker.Stop()
+		}
+	}()
+	if sub != nil {
+		defer sub.Unsubscribe()
+		subErr = sub.Err()
+	} else {
+		startPolling()
+	}
+
+	for {
+		var block *big.Int // nil means the latest block.
+		select {
+		case <-ctx.Done():
+			return
+		case head := <-heads:
+			block = head.Number
+		case <-poll:
+		case err := <-subErr:
+			log.WithError(err).Warn("New-head subscription failed, polling balance")
+			subErr = nil
+			startPolling()
+			continue
+		}
+
+		bal, err := c.ethClient.BalanceAt(ctx, common.Address(address.addr), block)
+		if err != nil {
+			log.WithError(err).Debug("Querying balance failed")
+			continue
+		}
+		if bal.Cmp(last) != 0 {
+			last = bal
+			h.OnBalanceChanged(address, &BigInt{new(big.Int).Set(bal)})
+		}
+	}
+}
+
+// Close ends the subscription. The handler is not called afterwards, unless
+// it is currently being called.
+func (s *BalanceSubscription) Close() {
+	s.cancel()
+}
@@ -106,6 +106,7 @@ func NewBigInts(length int) *BigInts {
 }
 
 // NewBalances creates a new BigInts of length two with the given values.
+// Use NewBigInts and BigInts.Set for more than two participants.
 func NewBalances(first, second *BigInt) *BigInts {
 	return &BigInts{values: []*big.Int{first.i, second.i}}
 }
@@ -0,0 +1,146 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"context"
+	"sync"
+
+	"perun.network/go-perun/log"
+	"perun.network/go-perun/wallet"
+	"perun.network/go-perun/wire"
+	"perun.network/go-perun/wire/net"
+)
+
+// Wire types of the prnm messages. They are external types and not part of
+// the Perun wire protocol, so only prnm peers can decode them.
+const (
+	msgAlias = wire.LastType + 32 + iota
+	msgPaymentRequest
+	msgMemo
+	msgRollover
+)
+
+type (
+	// msgBus wraps a net.Bus and handles the prnm messages that are sent
+	// alongside the go-perun messages. They are not forwarded to the go-perun
+	// client.
+	// It exchanges aliases with every peer before the first message of a
+	// session is sent to it or received from it.
+	msgBus struct {
+		*net.Bus
+		alias    aliasMsg
+		self     wire.Address
+		initiate bool // Whether we send our alias unrequested.
+		book     *addressBook
+
+		// onRequest is called for incoming payment requests. Requests of a
+		// sender whose previous request is still being handled are dropped.
+		// Must be set before the bus starts listening.
+		onRequest func(wire.Address, *paymentRequestMsg)
+		// onMemo is called synchronously for every incoming memo so that it
+		// is known before the update arrives. Must be set before the bus
+		// starts listening.
+		onMemo func(wire.Address, *memoMsg)
+		// onRollover is called synchronously for every incoming rollover
+		// announcement so that it is known before the proposal arrives. Must
+		// be set before the bus starts listening.
+		onRollover func(wire.Address, *rolloverMsg)
+
+		mu       sync.Mutex
+		greeted  map[wallet.AddrKey]bool
+		requests map[wallet.AddrKey]bool // Senders whose request is handled.
+	}
+
+	// msgConsumer intercepts the messages that the bus passes to the go-perun
+	// client.
+	msgConsumer struct {
+		wire.Consumer
+		b *msgBus
+	}
+)
+
+func newMsgBus(bus *net.Bus, self wire.Address, cfg *Config, book *addressBook) *msgBus {
+	b := &msgBus{
+		Bus:      bus,
+		alias:    aliasMsg{Alias: cfg.Alias},
+		self:     self,
+		initiate: cfg.ExchangeAlias,
+		book:     book,
+		greeted:  make(map[wallet.AddrKey]bool),
+		requests: make(map[wallet.AddrKey]bool),
+	}
+	copy(b.alias.AvatarHash[:], cfg.AvatarHash)
+	return b
+}
+
+// Publish sends our alias to the recipient if it was not greeted yet and then
+// publishes the envelope.
+func (b *msgBus) Publish(ctx context.Context, e *wire.Envelope) error {
+	if b.initiate && b.greet(e.Recipient) {
+		if err := b.sendAlias(ctx, e.Recipient, false); err != nil {
+			log.WithError(err).Warn("Sending alias failed")
+		}
+	}
+	return b.Bus.Publish(ctx, e)
+}
+
+// SubscribeClient subscribes the go-perun client to the bus and intercepts
+// all prnm messages.
+func (b *msgBus) SubscribeClient(c wire.Consumer, addr wire.Address) error {
+	return b.Bus.SubscribeClient(&msgConsumer{Consumer: c, b: b}, addr)
+}
+
+// Put handles prnm messages and forwards all other messages.
+func (c *msgConsumer) Put(e *wire.Envelope) {
+	m, isAlias := e.Msg.(*aliasMsg)
+	// A received alias that is not a reply always gets answered since the
+	// sender supports alias messages.
+	if (c.b.greet(e.Sender) && c.b.initiate) || (isAlias && !m.Reply) {
+		go c.b.replyAlias(e.Sender, isAlias)
+	}
+
+	switch msg := e.Msg.(type) {
+	case *aliasMsg:
+		c.b.handleAlias(e.Sender, msg)
+	case *paymentRequestMsg:
+		c.b.handleRequest(e.Sender, msg)
+	case *memoMsg:
+		c.b.onMemo(e.Sender, msg)
+	case *rolloverMsg:
+		c.b.onRollover(e.Sender, msg)
+	default:
+		c.Consumer.Put(e)
+	}
+}
+
+// handleRequest calls onRequest in the background unless a request of
+// `sender` is still being handled, in which case the request is dropped.
+func (b *msgBus) handleRequest(sender wire.Address, m *paymentRequestMsg) {
+	key := wallet.Key(sender)
+	b.mu.Lock()
+	pending := b.requests[key]
+	b.requests[key] = true
+	b.mu.Unlock()
+	if pending {
+		log.WithField("peer", sender).Warn("Dropped payment request while another one is pending")
+		return
+	}
+
+	go func() {
+		defer func() {
+			b.mu.Lock()
+			delete(b.requests, key)
+			b.mu.Unlock()
+		}()
+		b.onRequest(sender, m)
+	}()
+}
+
+// send publishes `msg` to `peer` without greeting it.
+func (b *msgBus) send(ctx context.Context, peer wire.Address, msg wire.Msg) error {
+	return b.Bus.Publish(ctx, &wire.Envelope{Sender: b.self, Recipient: peer, Msg: msg})
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	pkgtest "perun.network/go-perun/pkg/test"
+	"perun.network/go-perun/wallet"
+	"perun.network/go-perun/wire"
+)
+
+func TestMsgBus_HandleRequest(t *testing.T) {
+	rng := pkgtest.Prng(t)
+	alice, bob := newRandomAddress(rng), newRandomAddress(rng)
+	handled, release := make(chan wallet.Address, 10), make(chan struct{})
+	b := &msgBus{requests: make(map[wallet.AddrKey]bool)}
+	b.onRequest = func(sender wire.Address, _ *paymentRequestMsg) {
+		handled <- sender
+		<-release
+	}
+	req := &paymentRequestMsg{Amount: big.NewInt(1)}
+	next := func() wallet.Address {
+		select {
+		case sender := <-handled:
+			return sender
+		case <-time.After(testTimeout):
+			t.Fatal("request was not handled")
+			return nil
+		}
+	}
+
+	// A second request of Alice is dropped while the first one is handled,
+	// but Bob's request is handled.
+	b.handleRequest(alice, req)
+	assert.True(t, alice.Equals(next()))
+	b.handleRequest(alice, req)
+	b.handleRequest(bob, req)
+	assert.True(t, bob.Equals(next()))
+
+	// After the handler returned, Alice's requests are handled again.
+	release <- struct{}{}
+	release <- struct{}{}
+	require.Eventually(t, func() bool {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		return len(b.requests) == 0
+	}, testTimeout, 10*time.Millisecond)
+	assert.Len(t, handled, 0)
+	b.handleRequest(alice, req)
+	assert.True(t, alice.Equals(next()))
+	close(release)
+}
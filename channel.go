@@ -30,11 +30,16 @@ func (s *State) GetVersion() int64 {
 }
 
 // GetBalances returns a BigInts with length two containing the current
-// balances.
+// balances of the first asset.
 func (s *State) GetBalances() *BigInts {
 	return &BigInts{values: s.s.Balances[0]}
 }
 
+// GetAllocation returns the current balances of all assets.
+func (s *State) GetAllocation() *Allocation {
+	return &Allocation{assets: s.s.Assets, balances: s.s.Balances}
+}
+
 // IsFinal indicates that the channel is in its final state.
 // Such a state can immediately be settled on the blockchain.
 // A final state cannot be further progressed.
@@ -72,10 +77,12 @@ func (p *Params) GetParts() *Addresses {
 
 type (
 	// PaymentChannel is a convenience wrapper for go-perun/client.Channel
-	// which provides all necessary functionality of a two-party payment channel.
+	// which provides all necessary functionality of a payment channel.
+	// Participants are addressed by their index in the channel's Params.
 	// ref https://pkg.go.dev/perun.network/go-perun/client?tab=doc#Channel
 	PaymentChannel struct {
 		ch *client.Channel
+		c  *Client // back-reference for peer information
 	}
 
 	// ConcludedEventHandler handles channel conclusions.
@@ -87,45 +94,161 @@ type (
 	// ConcludedEventHandler if an channel is concluded.
 	ConcludedWatcher struct {
 		h ConcludedEventHandler
+		c *Client // back-reference for emitting events
+	}
+
+	// DisputeHandler handles all on-chain events of a channel.
+	// `timeout` is the Unix time in seconds at which the current dispute
+	// phase ends, or 0 if it is unknown.
+	DisputeHandler interface {
+		ConcludedEventHandler
+		// HandleRegistered is called when a state with `version` was
+		// registered on-chain. If it is not the latest version, the watcher
+		// refutes it with the latest state.
+		HandleRegistered(id []byte, version int64, timeout int64)
+		// HandleProgressed is called when an app channel was progressed
+		// on-chain into the state with `version`.
+		HandleProgressed(id []byte, version int64, timeout int64)
+	}
+
+	// disputeWatcher implements the AdjudicatorEventHandler and passes all
+	// events to the DisputeHandler.
+	disputeWatcher struct {
+		h DisputeHandler
+		c *Client
 	}
 )
 
 // HandleAdjudicatorEvent handles channel events emitted by the Adjudicator.
 func (w *ConcludedWatcher) HandleAdjudicatorEvent(e channel.AdjudicatorEvent) {
+	if w.c != nil {
+		w.c.emitAdjudicatorEvent(e)
+	}
 	if _, ok := e.(*channel.ConcludedEvent); ok {
 		id := e.ID()
 		w.h.HandleConcluded(id[:])
 	}
 }
 
+// HandleAdjudicatorEvent handles channel events emitted by the Adjudicator.
+func (w *disputeWatcher) HandleAdjudicatorEvent(e channel.AdjudicatorEvent) {
+	w.c.emitAdjudicatorEvent(e)
+	ev := adjudicatorEvent(e)
+	if ev == nil {
+		return
+	}
+	switch {
+	case w.h == nil:
+	case ev.Type == EventRegistered:
+		w.h.HandleRegistered(ev.ChannelID, ev.Version, ev.Timeout)
+	case ev.Type == EventProgressed:
+		w.h.HandleProgressed(ev.ChannelID, ev.Version, ev.Timeout)
+	case ev.Type == EventConcluded:
+		w.h.HandleConcluded(ev.ChannelID)
+	}
+}
+
 // Watch starts the channel watcher routine. It subscribes to RegisteredEvents
 // on the adjudicator. If an event is registered, it is handled by making sure
 // the latest state is registered and then all funds withdrawn to the receiver
 // specified in the adjudicator that was passed to the channel.
 // In case of a channel conclusion event, the given handler `h` is called.
+// All adjudicator events and errors are also passed to the
+// ChannelEventListener of the Client.
 //
 // If handling failed, the watcher routine returns the respective error. It is
-// the user's job to restart the watcher after the cause of the error got fixed.
+// the user's job to restart the watcher after the cause of the error got fixed,
+// unless the watchers are managed by the Client, see Client.EnableWatchers.
 // ref https://pkg.go.dev/perun.network/go-perun/client?tab=doc#Channel.Watch
 func (c *PaymentChannel) Watch(h ConcludedEventHandler) error {
-	w := &ConcludedWatcher{h: h}
-	return c.ch.Watch(w)
+	return c.watch(&ConcludedWatcher{h: h, c: c.c})
+}
+
+// WatchDisputes is like Watch but passes all adjudicator events to `h`, so
+// that the user can be warned when the peer registers a state.
+func (c *PaymentChannel) WatchDisputes(h DisputeHandler) error {
+	return c.watch(&disputeWatcher{h: h, c: c.c})
+}
+
+func (c *PaymentChannel) watch(w client.AdjudicatorEventHandler) error {
+	err := c.ch.Watch(w)
+	if err != nil {
+		c.c.emitError(c.ch, errors.WithMessage(err, "watching"))
+	}
+	return err
+}
+
+// Send pays `amount` of the first asset to the participant with index
+// `toIdx`. Only positive amounts are supported.
+func (c *PaymentChannel) Send(ctx *Context, toIdx int, amount *BigInt) error {
+	return c.SendAsset(ctx, 0, toIdx, amount, "")
 }
 
-// Send pays `amount` to the counterparty. Only positive amounts are supported.
-func (c *PaymentChannel) Send(ctx *Context, amount *BigInt) error {
+// SendWithMemo is like Send but attaches `memo` to the payment, for example an
+// invoice number. The memo is passed to the receiver's UpdateHandler and
+// stored by both sides, see GetMemo.
+func (c *PaymentChannel) SendWithMemo(ctx *Context, toIdx int, amount *BigInt, memo string) error {
+	return c.SendAsset(ctx, 0, toIdx, amount, memo)
+}
+
+// SendAsset pays `amount` of the asset at index `assetIdx` to the participant
+// with index `toIdx`. Only positive amounts are supported. `memo` is optional,
+// see SendWithMemo.
+func (c *PaymentChannel) SendAsset(ctx *Context, assetIdx, toIdx int, amount *BigInt, memo string) error {
 	if amount.i.Sign() < 1 {
 		return errors.New("Only positive amounts supported in send")
 	}
+	my := int(c.ch.Idx())
+	if toIdx < 0 || toIdx >= len(c.ch.Params().Parts) || toIdx == my {
+		return errors.New("invalid receiver index")
+	}
 
-	return c.ch.UpdateBy(ctx.ctx, func(state *channel.State) error {
-		my := c.ch.Idx()
-		other := 1 - my
-		bals := state.Allocation.Balances[0]
+	// Our payments are serialized so that every memo is sent for the version
+	// of its own update.
+	mu := c.c.chans.sendLock(c.ch.ID())
+	mu.Lock()
+	defer mu.Unlock()
+	state := c.ch.State()
+	if assetIdx < 0 || assetIdx >= len(state.Assets) {
+		return errors.New("asset index out of range")
+	}
+	version := state.Version + 1
+	// The memo is sent before the update since the channel is locked while
+	// the update is proposed. The receiver keys it by version.
+	if memo != "" {
+		if err := c.sendMemo(ctx, version, memo); err != nil {
+			return err
+		}
+	}
+	err := c.ch.UpdateBy(ctx.ctx, func(state *channel.State) error {
+		// The version is incremented after the update function returns.
+		if state.Version+1 != version {
+			return errors.New("channel was updated concurrently")
+		}
+		bals := state.Allocation.Balances[assetIdx]
 		bals[my].Sub(bals[my], amount.i)
-		bals[other].Add(bals[other], amount.i)
+		bals[toIdx].Add(bals[toIdx], amount.i)
 		return nil
 	})
+	if err != nil || memo == "" {
+		return err
+	}
+	return c.c.memos.put(c.ch.ID(), version, memo)
+}
+
+// IsSubChannel returns whether the channel is a sub-channel that is funded
+// from a parent channel.
+func (c *PaymentChannel) IsSubChannel() bool {
+	return c.ch.IsSubChannel()
+}
+
+// GetParent returns the parent channel of a sub-channel, or nil for ledger
+// channels.
+func (c *PaymentChannel) GetParent() *PaymentChannel {
+	if !c.ch.IsSubChannel() {
+		return nil
+	}
+	return &PaymentChannel{c.ch.Parent(), c.c}
 }
 
 // GetIdx returns our index in the channel.
@@ -143,14 +266,36 @@ func (c *PaymentChannel) Finalize(ctx *Context) error {
 }
 
 // Settle settles the channel: it is made sure that the current state is
-// registered and the final balance withdrawn. This call blocks until the
-// channel has been successfully withdrawn.
+// registered and the final balance withdrawn to the Config's
+// WithdrawalReceiver. This call blocks until the channel has been successfully
+// withdrawn.
 // Call Finalize before settling a channel to avoid waiting a full
 // challenge duration.
 // If the `secondary` flag is set to true, the Adjudicator runs an optimized
 // protocol, where it is assumed that the other peer also settles the channel.
+//
+// Sub-channels must be finalized and are settled off-chain by withdrawing
+// their final balances into the parent channel. Both participants have to
+// call Settle in this case. A parent channel can only be settled after all its
+// sub-channels were settled.
 // ref https://pkg.go.dev/perun.network/go-perun/client?tab=doc#Channel.Settle
 func (c *PaymentChannel) Settle(ctx *Context, secondary bool) error {
+	err := c.settle(ctx, secondary)
+	if err != nil {
+		c.c.emitError(c.ch, err)
+	} else {
+		c.c.emitChannel(EventWithdrawn, c.ch.ID())
+	}
+	return err
+}
+
+func (c *PaymentChannel) settle(ctx *Context, secondary bool) error {
+	if c.ch.IsSubChannel() {
+		return c.ch.Settle(ctx.ctx, secondary)
+	}
+	if locked := len(c.ch.State().Locked); locked != 0 {
+		return errors.Errorf("%d sub-channels must be settled first", locked)
+	}
 	if err := c.ch.Register(ctx.ctx); err != nil {
 		return errors.WithMessage(err, "registering")
 	}
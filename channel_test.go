@@ -0,0 +1,44 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/client"
+	pkgtest "perun.network/go-perun/pkg/test"
+)
+
+func TestPaymentChannel_SettleWithSubChannels(t *testing.T) {
+	rng := pkgtest.Prng(t)
+	tc := newTestChannel(t, rng, &Config{})
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	// Alice opens a sub-channel, which locks funds in the parent.
+	initBals := &channel.Allocation{
+		Assets:   tc.alice.State().Assets,
+		Balances: channel.Balances{{big.NewInt(10), big.NewInt(10)}},
+	}
+	prop, err := client.NewSubChannelProposal(tc.alice.ID(), 60, initBals, client.WithRandomNonce())
+	require.NoError(t, err)
+	_, err = tc.aliceClient.ProposeChannel(ctx, prop)
+	require.NoError(t, err)
+	require.Len(t, tc.alice.State().Locked, 1)
+
+	sctx := ContextWithTimeout(int(testTimeout.Seconds()))
+	defer sctx.Cancel()
+	err = (&PaymentChannel{tc.alice, tc.bob}).Settle(sctx, false)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "sub-channels must be settled first")
+	}
+}
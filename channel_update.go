@@ -5,7 +5,21 @@
 
 package prnm
 
-import "perun.network/go-perun/client"
+import (
+	"context"
+	"math/big"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/client"
+	"perun.network/go-perun/log"
+)
+
+// updateRejectTimeout is the time that sending an automatic rejection of an
+// update may take.
+const updateRejectTimeout = 10 * time.Second
 
 type (
 	// An UpdateHandler decides how to handle incoming channel update requests
@@ -14,20 +28,29 @@ type (
 		// HandleUpdate is the user callback called by the channel controller
 		// on an incoming update request.
 		HandleUpdate(*ChannelUpdate, *UpdateResponder)
+		// HandlePaymentRequest is the user callback called by the Client on
+		// an incoming payment request, see PaymentChannel.RequestPayment.
+		// Further requests of the same peer are dropped until it returns,
+		// so it may block until the user decided about the request.
+		HandlePaymentRequest(*PaymentRequest)
 	}
 
 	// updateHandler implements a client.UpdateHandler wrapping a prnm
 	// UpdateHandler
 	updateHandler struct {
+		c *Client // back-reference for update validation
 		h UpdateHandler
 	}
 
 	// ChannelUpdate is a channel update proposal.
-	// If the ActorIdx is the own channel index, this is a payment request.
+	// The ActorIdx is the index of the participant in the channel's Params.
+	// Payment requests are not sent as updates but passed to
+	// UpdateHandler.HandlePaymentRequest.
 	// If State.IsFinal() is true, this is a request to finalize the channel.
 	ChannelUpdate struct {
 		State    *State // Proposed new state.
 		ActorIdx int    // Who is transferring funds.
+		Memo     string // Optional description of the payment.
 	}
 
 	// An UpdateResponder lets the user respond to a channel update. If the
@@ -35,7 +58,10 @@ type (
 	// Reject(). Only a single function must be called and every further call
 	// causes a panic.
 	UpdateResponder struct {
-		r *client.UpdateResponder
+		c      *Client // back-reference for storing the memo in Accept
+		update client.ChannelUpdate
+		memo   string
+		r      *client.UpdateResponder
 	}
 )
 
@@ -43,20 +69,107 @@ type (
 // passed types from the go-perun/client package into their local counterparts
 // and then calling the prnm.UpdateHandler.
 func (h *updateHandler) HandleUpdate(_update client.ChannelUpdate, _resp *client.UpdateResponder) {
+	if h.c.cfg.ValidateUpdates {
+		if err := h.c.validateUpdate(_update); err != nil {
+			log.WithField("channel", _update.State.ID).Warn("Rejecting update: ", err)
+			ctx, cancel := context.WithTimeout(context.Background(), updateRejectTimeout)
+			defer cancel()
+			if err := _resp.Reject(ctx, err.Error()); err != nil {
+				log.WithError(err).Warn("Rejecting update failed")
+			}
+			return
+		}
+	}
+	var memo string
+	if ch, ok := h.c.chans.get(_update.State.ID); ok {
+		memo = h.c.memos.takePending(_update.State.ID, _update.State.Version, ch.Peers()[_update.ActorIdx])
+	}
 	update := &ChannelUpdate{
 		State:    &State{_update.State},
 		ActorIdx: int(_update.ActorIdx),
+		Memo:     memo,
 	}
-	resp := &UpdateResponder{r: _resp}
+	resp := &UpdateResponder{c: h.c, update: _update, memo: memo, r: _resp}
 	h.h.HandleUpdate(update, resp)
 }
 
 // Accept lets the user signal that they want to accept the channel update.
+// The memo of the update is stored once it was accepted.
 func (r *UpdateResponder) Accept(ctx *Context) error {
-	return r.r.Accept(ctx.ctx)
+	if err := r.r.Accept(ctx.ctx); err != nil {
+		return err
+	}
+	if r.memo == "" {
+		return nil
+	}
+	return r.c.memos.put(r.update.State.ID, r.update.State.Version, r.memo)
 }
 
 // Reject lets the user signal that they reject the channel update.
 func (r *UpdateResponder) Reject(ctx *Context, reason string) error {
 	return r.r.Reject(ctx.ctx, reason)
 }
+
+// validateUpdate compares the proposed state of `update` with the current state
+// of its channel and returns the reason why it should be rejected, if any.
+// It is called while go-perun holds the channel's lock, so it must only use
+// the state copy of the registry.
+func (c *Client) validateUpdate(update client.ChannelUpdate) error {
+	ch, ok := c.chans.get(update.State.ID)
+	if !ok {
+		return errors.New("unknown channel")
+	}
+	cur, ok := c.chans.state(update.State.ID)
+	if !ok {
+		return errors.New("unknown channel state")
+	}
+	return checkUpdate(cur, update.State, update.ActorIdx, ch.Idx())
+}
+
+// checkUpdate checks the transition from `cur` to `next` by `actor`. `idx` is
+// our index in the channel.
+func checkUpdate(cur, next *channel.State, actor, idx channel.Index) error {
+	if next.Version != cur.Version+1 {
+		return errors.Errorf("expected version %d, got %d", cur.Version+1, next.Version)
+	}
+	if err := channel.AssetsAssertEqual(cur.Assets, next.Assets); err != nil {
+		return errors.WithMessage(err, "assets changed")
+	}
+	for a := range cur.Balances {
+		if len(next.Balances[a]) != len(cur.Balances[a]) {
+			return errors.Errorf("number of balances of asset %d changed", a)
+		}
+	}
+	for _, sub := range next.Locked {
+		if len(sub.Bals) != len(next.Assets) {
+			return errors.Errorf("number of locked balances of sub-channel %x is invalid", sub.ID)
+		}
+	}
+	// Funds that are locked in sub-channels still belong to the channel.
+	sumCur, sumNext := totals(cur), totals(next)
+	for a := range sumCur {
+		if sumCur[a].Cmp(sumNext[a]) != 0 {
+			return errors.Errorf("total of asset %d changed", a)
+		}
+	}
+	if actor == idx {
+		return nil
+	}
+	for a := range cur.Balances {
+		if next.Balances[a][idx].Cmp(cur.Balances[a][idx]) < 0 {
+			return errors.Errorf("balance of asset %d decreased by peer", a)
+		}
+	}
+	return nil
+}
+
+// totals returns the sum of the balances and locked funds of `s` per asset.
+func totals(s *channel.State) []*big.Int {
+	sum := s.Balances.Sum()
+	for _, sub := range s.Locked {
+		for a, bal := range sub.Bals {
+			sum[a].Add(sum[a], bal)
+		}
+	}
+	return sum
+}
@@ -0,0 +1,102 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	ethwallet "perun.network/go-perun/backend/ethereum/wallet"
+	"perun.network/go-perun/channel"
+	pkgtest "perun.network/go-perun/pkg/test"
+)
+
+func TestCheckUpdate(t *testing.T) {
+	const us, peer = channel.Index(1), channel.Index(0)
+	asset := &ethwallet.Address{}
+	state := func(version uint64, bals []int64, locked ...int64) *channel.State {
+		s := &channel.State{Version: version}
+		s.Assets = []channel.Asset{asset}
+		s.Balances = channel.Balances{make([]channel.Bal, len(bals))}
+		for i, b := range bals {
+			s.Balances[0][i] = big.NewInt(b)
+		}
+		for _, l := range locked {
+			s.Locked = append(s.Locked, channel.SubAlloc{Bals: []channel.Bal{big.NewInt(l)}})
+		}
+		return s
+	}
+	cur := state(5, []int64{10, 10})
+
+	tests := []struct {
+		name  string
+		next  *channel.State
+		actor channel.Index
+		err   string
+	}{
+		{"payment to us", state(6, []int64{7, 13}), peer, ""},
+		{"payment by us", state(6, []int64{13, 7}), us, ""},
+		{"finalization", state(6, []int64{10, 10}), peer, ""},
+		{"sub-channel funding", state(6, []int64{8, 7}, 5), us, ""},
+		{"skipped version", state(7, []int64{7, 13}), peer, "expected version 6"},
+		{"old version", state(5, []int64{7, 13}), peer, "expected version 6"},
+		{"changed total", state(6, []int64{10, 11}), peer, "total of asset 0 changed"},
+		{"locked funds created", state(6, []int64{10, 10}, 1), us, "total of asset 0 changed"},
+		{"decrease by peer", state(6, []int64{13, 7}), peer, "decreased by peer"},
+		{"changed dimension", state(6, []int64{10, 5, 5}), peer, "number of balances"},
+		{"invalid locked dimension", &channel.State{
+			Version:    6,
+			Allocation: channel.Allocation{Assets: cur.Assets, Balances: cur.Balances, Locked: []channel.SubAlloc{{}}},
+		}, us, "number of locked balances"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkUpdate(cur, tt.next, tt.actor, us)
+			if tt.err == "" {
+				assert.NoError(t, err)
+			} else if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tt.err)
+			}
+		})
+	}
+}
+
+// TestValidateUpdates checks that the validation of incoming updates does not
+// deadlock with go-perun and rejects invalid updates.
+func TestValidateUpdates(t *testing.T) {
+	rng := pkgtest.Prng(t)
+	tc := newTestChannel(t, rng, &Config{ValidateUpdates: true})
+	ch, uh := tc.alice, tc.uh
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	transfer := func(from, to channel.Index) func(*channel.State) error {
+		return func(s *channel.State) error {
+			s.Balances[0][from].Sub(s.Balances[0][from], big.NewInt(5))
+			s.Balances[0][to].Add(s.Balances[0][to], big.NewInt(5))
+			return nil
+		}
+	}
+
+	// Alice pays Bob, which Bob accepts.
+	require.NoError(t, ch.UpdateBy(ctx, transfer(0, 1)))
+	select {
+	case up := <-uh.accepted:
+		assert.Equal(t, int64(1), up.State.GetVersion())
+	case <-ctx.Done():
+		t.Fatal("valid update was not accepted")
+	}
+
+	// Alice takes funds from Bob, which Bob rejects without asking the
+	// UpdateHandler.
+	assert.Error(t, ch.UpdateBy(ctx, transfer(1, 0)))
+	assert.Len(t, uh.accepted, 0)
+	assert.Equal(t, uint64(1), ch.State().Version)
+}
@@ -0,0 +1,211 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+
+	ethwallet "perun.network/go-perun/backend/ethereum/wallet"
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/client"
+	"perun.network/go-perun/log"
+	"perun.network/go-perun/wire"
+)
+
+// chanRegistry keeps track of all open channels of a Client. Channels are
+// added when they are created or restored and removed when they are closed.
+// It also holds a copy of the current state of every channel since go-perun
+// holds the channel's lock while calling the UpdateHandler, so the state can
+// not be read from the channel during update validation.
+type chanRegistry struct {
+	mu     sync.RWMutex
+	chans  map[channel.ID]*client.Channel
+	states map[channel.ID]*channel.State
+	sends  map[channel.ID]*sync.Mutex // Serialize our payments, see sendLock.
+	onNew  NewChannelCallback
+}
+
+func newChanRegistry() *chanRegistry {
+	return &chanRegistry{
+		chans:  make(map[channel.ID]*client.Channel),
+		states: make(map[channel.ID]*channel.State),
+		sends:  make(map[channel.ID]*sync.Mutex),
+	}
+}
+
+// handleNewChannel is called by go-perun for every new or restored channel.
+// It records the payments of the channel in the payment history.
+func (c *Client) handleNewChannel(ch *client.Channel) {
+	id, idx := ch.ID(), ch.Idx()
+	c.chans.setState(id, ch.State())
+	ch.OnUpdate(func(from, to *channel.State) {
+		c.chans.setState(id, to)
+		c.emitUpdated(id, c.history.record(id, idx, from, to))
+	})
+	for i, peer := range ch.Peers() {
+		if channel.Index(i) == idx {
+			continue
+		}
+		if err := c.addrBook.addChannelPeer(*peer.(*ethwallet.Address)); err != nil {
+			log.WithField("channel", id).WithError(err).Warn("Adding peer to address book failed")
+		}
+	}
+	ch.OnCloseAlways(func() {
+		c.memos.dropPending(id)
+		c.rollovers.dropPending(id)
+		c.emitChannel(EventClosed, id)
+	})
+	// Register the channel before its watcher starts, so that dispute handlers
+	// can look it up and a concurrent EnableWatchers does not miss it.
+	callback := c.chans.add(ch)
+	c.startWatcher(ch)
+	if callback != nil {
+		callback.OnNew(&PaymentChannel{ch, c})
+	}
+}
+
+// add adds `ch` to the registry and returns the NewChannelCallback.
+func (r *chanRegistry) add(ch *client.Channel) NewChannelCallback {
+	id := ch.ID()
+	r.mu.Lock()
+	r.chans[id] = ch
+	callback := r.onNew
+	r.mu.Unlock()
+
+	ch.OnCloseAlways(func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.chans[id] == ch {
+			delete(r.chans, id)
+			delete(r.states, id)
+			delete(r.sends, id)
+		}
+	})
+	return callback
+}
+
+func (r *chanRegistry) setCallback(callback NewChannelCallback) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.onNew = callback
+}
+
+// isPeer returns whether `addr` is a peer of `ch` other than us.
+func isPeer(ch *client.Channel, addr wire.Address) bool {
+	for i, p := range ch.Peers() {
+		if channel.Index(i) != ch.Idx() && p.Equals(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// sendLock returns the mutex that serializes our payments in channel `id`.
+func (r *chanRegistry) sendLock(id channel.ID) *sync.Mutex {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.chans[id]; !ok {
+		return new(sync.Mutex)
+	}
+	mu, ok := r.sends[id]
+	if !ok {
+		mu = new(sync.Mutex)
+		r.sends[id] = mu
+	}
+	return mu
+}
+
+// setState stores a copy of `s` as the current state of channel `id` unless a
+// newer state is known already.
+func (r *chanRegistry) setState(id channel.ID, s *channel.State) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if cur, ok := r.states[id]; ok && cur.Version > s.Version {
+		return
+	}
+	r.states[id] = s.Clone()
+}
+
+// state returns the current state of channel `id`. It must not be modified.
+func (r *chanRegistry) state(id channel.ID) (*channel.State, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	s, ok := r.states[id]
+	return s, ok
+}
+
+func (r *chanRegistry) get(id channel.ID) (*client.Channel, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ch, ok := r.chans[id]
+	return ch, ok
+}
+
+// filter returns all channels for which `pred` returns true.
+func (r *chanRegistry) filter(pred func(*client.Channel) bool) []*client.Channel {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	chs := make([]*client.Channel, 0, len(r.chans))
+	for _, ch := range r.chans {
+		if pred(ch) {
+			chs = append(chs, ch)
+		}
+	}
+	return chs
+}
+
+// PaymentChannels is a slice of PaymentChannel's
+type PaymentChannels struct {
+	values []*client.Channel
+	c      *Client
+}
+
+// Length returns the length of the PaymentChannels slice.
+func (cs *PaymentChannels) Length() int {
+	return len(cs.values)
+}
+
+// Get returns the element at the given index.
+func (cs *PaymentChannels) Get(index int) (*PaymentChannel, error) {
+	if index < 0 || index >= len(cs.values) {
+		return nil, errors.New("get: index out of range")
+	}
+	return &PaymentChannel{cs.values[index], cs.c}, nil
+}
+
+// GetChannels returns all open channels of the Client, including restored
+// ones. Closed channels are removed automatically.
+func (c *Client) GetChannels() *PaymentChannels {
+	return &PaymentChannels{c.chans.filter(func(*client.Channel) bool { return true }), c}
+}
+
+// GetChannel returns the open channel with the given ID.
+func (c *Client) GetChannel(id []byte) (*PaymentChannel, error) {
+	var cid channel.ID
+	if len(id) != len(cid) {
+		return nil, errors.New("invalid channel ID length")
+	}
+	copy(cid[:], id)
+	ch, ok := c.chans.get(cid)
+	if !ok {
+		return nil, errors.New("unknown channel ID")
+	}
+	return &PaymentChannel{ch, c}, nil
+}
+
+// GetChannelsWithPeer returns all open channels with the peer `perunID`.
+func (c *Client) GetChannelsWithPeer(perunID *Address) *PaymentChannels {
+	return &PaymentChannels{c.chans.filter(func(ch *client.Channel) bool {
+		for _, p := range ch.Peers() {
+			if p.Equals(&perunID.addr) {
+				return true
+			}
+		}
+		return false
+	}), c}
+}
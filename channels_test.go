@@ -0,0 +1,83 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"perun.network/go-perun/channel"
+	pkgtest "perun.network/go-perun/pkg/test"
+)
+
+func TestChanRegistry_SetState(t *testing.T) {
+	id := channel.ID{1}
+	tests := []struct {
+		name     string
+		versions []uint64 // Versions that are set in this order.
+		want     uint64
+	}{
+		{"single", []uint64{0}, 0},
+		{"ascending", []uint64{0, 1, 2}, 2},
+		{"older is ignored", []uint64{3, 2}, 3},
+		{"same version replaces", []uint64{1, 1}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newChanRegistry()
+			for _, v := range tt.versions {
+				r.setState(id, balState(v, 10, 10))
+			}
+			s, ok := r.state(id)
+			require.True(t, ok)
+			assert.Equal(t, tt.want, s.Version)
+		})
+	}
+
+	// The registry keeps a copy.
+	r := newChanRegistry()
+	s := balState(1, 10, 10)
+	r.setState(id, s)
+	s.Balances[0][0].SetInt64(0)
+	cached, _ := r.state(id)
+	assert.Equal(t, big.NewInt(10), cached.Balances[0][0])
+
+	_, ok := r.state(channel.ID{2})
+	assert.False(t, ok)
+}
+
+func TestChanRegistry_SendLock(t *testing.T) {
+	rng := pkgtest.Prng(t)
+	tc := newTestChannel(t, rng, &Config{})
+	r, id := tc.bob.chans, tc.alice.ID()
+
+	mu := r.sendLock(id)
+	assert.Same(t, mu, r.sendLock(id), "channel must have one lock")
+	unknown := r.sendLock(channel.ID{1})
+	assert.NotSame(t, unknown, r.sendLock(channel.ID{1}), "unknown channels must get fresh locks")
+	assert.NotContains(t, r.sends, channel.ID{1})
+}
+
+func TestChanRegistry_RemoveOnClose(t *testing.T) {
+	rng := pkgtest.Prng(t)
+	tc := newTestChannel(t, rng, &Config{})
+	r, id := tc.bob.chans, tc.alice.ID()
+	ch, ok := r.get(id)
+	require.True(t, ok)
+	r.sendLock(id)
+	_, ok = r.state(id)
+	require.True(t, ok)
+
+	require.NoError(t, ch.Close())
+	_, ok = r.get(id)
+	assert.False(t, ok)
+	_, ok = r.state(id)
+	assert.False(t, ok)
+	assert.NotContains(t, r.sends, id)
+}
@@ -9,17 +9,21 @@ import (
 	"context"
 	"fmt"
 	"math/big"
+	"sync"
 	"time"
 
 	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/pkg/errors"
 
+	"perun.network/go-perun/backend/ethereum/bindings/peruntoken"
 	ethchannel "perun.network/go-perun/backend/ethereum/channel"
 	ethwallet "perun.network/go-perun/backend/ethereum/wallet"
 	"perun.network/go-perun/backend/ethereum/wallet/keystore"
+	"perun.network/go-perun/channel"
 	"perun.network/go-perun/channel/persistence/keyvalue"
 	"perun.network/go-perun/client"
 	"perun.network/go-perun/log"
@@ -37,15 +41,32 @@ type (
 	Client struct {
 		cfg *Config
 
-		ethClient *ethclient.Client
-		client    *client.Client
-		persister *keyvalue.PersistRestorer
+		ethClient   *ethclient.Client
+		cb          ethchannel.ContractBackend
+		client      *client.Client
+		adjudicator *receiverAdjudicator
+		assets      *assetRegistry
+		apps        *appRegistry
+		chans       *chanRegistry
+		persister   *keyvalue.PersistRestorer
+		gas         gasPolicy
+		txs         *txStore
+		ctx         context.Context // Canceled when the Client is closed.
+		cancel      context.CancelFunc
 
 		wallet  *keystore.Wallet
 		onChain wallet.Account
 
-		dialer *simple.Dialer
-		bus    *net.Bus
+		addrBook  *addressBook
+		memos     *memoStore
+		history   *historyStore
+		rollovers *rolloverStore
+		events    *eventQueue
+		watchers  *watcherRegistry
+		bus       *msgBus
+
+		uhMu sync.RWMutex
+		uh   UpdateHandler // Set by Handle.
 	}
 
 	// NewChannelCallback wraps a `func(*PaymentChannel)`
@@ -59,14 +80,22 @@ type (
 // The Client:
 //  - imports the keystore and unlocks the account
 //  - listens on IP:port
-//  - connects to the eth node
+//  - connects to the eth node and checks that its chain ID matches the
+//    `cfg`s ChainID
 //  - in case either the Adjudicator and AssetHolder of the `cfg` are nil, it
-//    deploys needed contract. There is currently no check that the
-//    correct bytecode is deployed to the given addresses if they are
-//    not nil.
+//    deploys needed contract. Otherwise it checks that the correct bytecode
+//    is deployed to the given addresses and returns an
+//    InvalidContractError if not.
+//  - does the same for the TokenAssetHolder if the `cfg`s Token is not nil.
 //  - sets the `cfg`s Adjudicator and AssetHolder to the deployed contracts
 //    addresses in case they were deployed.
 func NewClient(ctx *Context, cfg *Config, w *Wallet) (*Client, error) {
+	if len(cfg.AvatarHash) != 0 && len(cfg.AvatarHash) != 32 {
+		return nil, errors.New("avatar hash must be 32 bytes long")
+	}
+	if len(cfg.Alias) > maxAliasLen {
+		return nil, errors.Errorf("alias must be at most %d bytes long", maxAliasLen)
+	}
 	endpoint := fmt.Sprintf("%s:%d", cfg.IP, cfg.Port)
 	listener, err := simple.NewTCPListener(endpoint)
 	if err != nil {
@@ -83,38 +112,77 @@ func NewClient(ctx *Context, cfg *Config, w *Wallet) (*Client, error) {
 		return nil, errors.WithMessage(err, "finding account")
 	}
 
-	signer := types.NewEIP155Signer(big.NewInt(1337))
-	cb := ethchannel.NewContractBackend(ethClient, keystore.NewTransactor(*w.w, signer))
-	if err := setupContracts(ctx.ctx, cb, acc.Account, cfg); err != nil {
+	chainID, err := checkChainID(ctx.ctx, ethClient, cfg.ChainID)
+	if err != nil {
+		return nil, err
+	}
+	gas, err := newGasPolicy(cfg)
+	if err != nil {
+		return nil, err
+	}
+	signer := types.NewEIP155Signer(chainID)
+	lifetime, cancel := context.WithCancel(context.Background())
+	txs := newTxStore(lifetime, ethClient, acc.Account.Address)
+	cb := ethchannel.NewContractBackend(&txRecorder{ethClient, txs}, newGasTransactor(keystore.NewTransactor(*w.w, signer), gas))
+	if err := setupContracts(withTxPurpose(ctx.ctx, TxDeploy, channel.ID{}), cb, acc.Account, cfg); err != nil {
+		cancel()
 		return nil, errors.WithMessage(err, "setting up contracts")
 	}
 
-	bus := net.NewBus(acc, dialer)
-	adjudicator := ethchannel.NewAdjudicator(cb, common.Address(cfg.Adjudicator.addr), acc.Account.Address, acc.Account)
-	accs := map[ethchannel.Asset]accounts.Account{cfg.AssetHolder.addr: acc.Account}
-	depositor := new(ethchannel.ETHDepositor)
-	deps := map[ethchannel.Asset]ethchannel.Depositor{cfg.AssetHolder.addr: depositor}
+	addrBook, memos, rollovers := newAddressBook(dialer), newMemoStore(), newRolloverStore()
+	bus := newMsgBus(net.NewBus(acc, dialer), acc.Address(), cfg, addrBook)
+	receiver := acc.Account.Address
+	if cfg.WithdrawalReceiver != nil {
+		receiver = common.Address(cfg.WithdrawalReceiver.addr)
+	}
+	adjudicator := newReceiverAdjudicator(cb, common.Address(cfg.Adjudicator.addr), receiver, acc.Account)
+	assets := newAssetRegistry(cb, acc.Account)
+	assets.register(cfg.AssetHolder.addr, new(ethchannel.ETHDepositor))
+	if cfg.Token != nil {
+		assets.register(cfg.TokenAssetHolder.addr, &ethchannel.ERC20Depositor{Token: common.Address(cfg.Token.addr)})
+	}
 
-	funder := ethchannel.NewFunder(cb, accs, deps)
-	c, err := client.New(acc.Address(), bus, funder, adjudicator, w.w)
+	c, err := client.New(acc.Address(), bus, assets, adjudicator, w.w)
 	if err != nil {
+		cancel()
 		return nil, errors.WithMessage(err, "creating client")
 	}
+	pc := &Client{cfg: cfg, ethClient: ethClient,
+		cb:          cb,
+		client:      c,
+		adjudicator: adjudicator,
+		assets:      assets,
+		apps:        newAppRegistry(),
+		chans:       newChanRegistry(),
+		persister:   nil,
+		gas:         gas,
+		txs:         txs,
+		ctx:         lifetime,
+		cancel:      cancel,
+		wallet:      w.w,
+		onChain:     acc,
+		addrBook:    addrBook,
+		memos:       memos,
+		rollovers:   rollovers,
+		history:     newHistoryStore(),
+		events:      newEventQueue(),
+		watchers:    newWatcherRegistry(),
+		bus:         bus}
+	c.OnNewChannel(pc.handleNewChannel)
+	bus.onRequest = pc.handlePaymentRequest
+	bus.onMemo = pc.handleMemo
+	bus.onRollover = pc.handleRollover
 	go bus.Listen(listener)
 
-	return &Client{cfg: cfg, ethClient: ethClient,
-		client:    c,
-		persister: nil,
-		wallet:    w.w,
-		onChain:   acc,
-		dialer:    dialer,
-		bus:       bus}, nil
+	return pc, nil
 }
 
 // Close closes the client and its PersistRestorer to synchronize the database.
 // ref https://pkg.go.dev/perun.network/go-perun/client?tab=doc#Channel.Close
 // ref https://pkg.go.dev/perun.network/go-perun/channel/persistence/keyvalue?tab=doc#PersistRestorer.Close
 func (c *Client) Close() error {
+	defer c.events.close()
+	defer c.cancel()
 	if err := c.client.Close(); err != nil {
 		return errors.WithMessage(err, "closing client")
 	}
@@ -132,7 +200,16 @@ func (c *Client) Close() error {
 // Incoming proposals and updates are forwarded to the passed handlers.
 // ref https://pkg.go.dev/perun.network/go-perun/client?tab=doc#Client.Handle
 func (c *Client) Handle(ph ProposalHandler, uh UpdateHandler) {
-	c.client.Handle(&proposalHandler{c: c, h: ph}, &updateHandler{h: uh})
+	c.uhMu.Lock()
+	c.uh = uh
+	c.uhMu.Unlock()
+	c.client.Handle(&proposalHandler{c: c, h: ph}, &updateHandler{c: c, h: uh})
+}
+
+func (c *Client) updateHandler() UpdateHandler {
+	c.uhMu.RLock()
+	defer c.uhMu.RUnlock()
+	return c.uh
 }
 
 // OnNewChannel sets a handler to be called whenever a new channel is created
@@ -142,15 +219,17 @@ func (c *Client) Handle(ph ProposalHandler, uh UpdateHandler) {
 // Start the watcher routine here, if needed.
 // ref https://pkg.go.dev/perun.network/go-perun/client?tab=doc#Client.OnNewChannel
 func (c *Client) OnNewChannel(callback NewChannelCallback) {
-	c.client.OnNewChannel(func(ch *client.Channel) {
-		callback.OnNew(&PaymentChannel{ch})
-	})
+	c.chans.setCallback(callback)
 }
 
 // EnablePersistence loads or creates a levelDB database at the given `dbPath`
 // and tries to restore all channels from it.
 // After this function was successfully called, all changes to the Client are
 // saved to the database.
+// Peers that were added with AddPeer are stored in the same database and all
+// previously stored peers are added to the Client again.
+// The payment history and memos of all channels are stored in it as well, as
+// are all pending transactions, see GetPendingTransactions.
 // This function is not thread safe.
 // ref https://pkg.go.dev/perun.network/go-perun/client?tab=doc#Client.EnablePersistence
 func (c *Client) EnablePersistence(dbPath string) (err error) {
@@ -160,6 +239,21 @@ func (c *Client) EnablePersistence(dbPath string) (err error) {
 	if err != nil {
 		return errors.WithMessage(err, "creating/loading database")
 	}
+	if err := c.addrBook.enablePersistence(db); err != nil {
+		return errors.WithMessage(err, "loading address book")
+	}
+	if err := c.memos.enablePersistence(db); err != nil {
+		return errors.WithMessage(err, "storing memos")
+	}
+	if err := c.history.enablePersistence(db); err != nil {
+		return errors.WithMessage(err, "storing payment history")
+	}
+	if err := c.rollovers.enablePersistence(db); err != nil {
+		return errors.WithMessage(err, "storing rollovers")
+	}
+	if err := c.txs.enablePersistence(db); err != nil {
+		return errors.WithMessage(err, "storing transactions")
+	}
 	c.persister = keyvalue.NewPersistRestorer(db)
 	c.client.EnablePersistence(c.persister)
 	return nil
@@ -168,26 +262,70 @@ func (c *Client) EnablePersistence(dbPath string) (err error) {
 // Restore restores all channels from persistence. Channels are restored in
 // parallel. Newly restored channels should be acquired through the
 // OnNewChannel callback.
+// The network addresses of the peers are taken from the address book, see
+// AddPeer.
 // Note that connections are currently established serially, so allow for
 // enough time in the passed context.
+// Transactions that were pending when the Client was stopped are awaited
+// again afterwards.
 // ref https://pkg.go.dev/perun.network/go-perun/client?tab=doc#Client.Restore
 func (c *Client) Restore(ctx *Context) error {
 	if c.persister == nil {
 		return errors.New("persistence not enabled")
 	}
-	return c.client.Restore(ctx.ctx)
+	if err := c.client.Restore(ctx.ctx); err != nil {
+		return err
+	}
+	return errors.WithMessage(c.txs.awaitAll(), "awaiting pending transactions")
 }
 
-// AddPeer adds a new peer to the client. Must be called before proposing
-// a new channel with said peer. Wraps go-perun/peer/net/Dialer.Register.
-// ref https://pkg.go.dev/perun.network/go-perun/peer/net?tab=doc#Dialer.Register
-func (c *Client) AddPeer(perunID *Address, host string, port int) {
-	c.dialer.Register((*ethwallet.Address)(&perunID.addr), fmt.Sprintf("%s:%d", host, port))
+// checkChainID queries the chain ID of the connected ETH node and returns an
+// error if it differs from `expected`.
+func checkChainID(ctx context.Context, ethClient *ethclient.Client, expected int64) (*big.Int, error) {
+	chainID, err := ethClient.ChainID(ctx)
+	if err != nil {
+		return nil, errors.WithMessage(err, "querying chain ID")
+	}
+	if chainID.Cmp(big.NewInt(expected)) != 0 {
+		return nil, errors.Errorf("chain ID mismatch: configured %d, ETH node reports %v", expected, chainID)
+	}
+	return chainID, nil
+}
+
+// InvalidContractError is returned by NewClient if the bytecode at a
+// configured contract address does not match the contract that go-perun
+// expects, or if the AssetHolder does not point to the configured Adjudicator.
+type InvalidContractError struct {
+	Contract string   // Name of the contract, e.g. "Adjudicator".
+	Address  *Address // Configured address of the contract.
+	cause    error
+}
+
+// Error returns the error message.
+func (e *InvalidContractError) Error() string {
+	return fmt.Sprintf("invalid %s contract at %s: %v", e.Contract, e.Address.ToHex(), e.cause)
+}
+
+// Unwrap returns the underlying validation error.
+func (e *InvalidContractError) Unwrap() error {
+	return e.cause
+}
+
+// validateContract wraps errors that originate from invalid contract code into
+// an InvalidContractError. All other errors are returned with a message.
+func validateContract(err error, contract string, addr *Address) error {
+	if err == nil {
+		return nil
+	}
+	if ethchannel.IsErrInvalidContractCode(err) {
+		return &InvalidContractError{Contract: contract, Address: addr, cause: err}
+	}
+	return errors.WithMessagef(err, "validating %s", contract)
 }
 
 // setupContracts checks which contracts of the `cfg` are nil and deploys them
 // to the blockchain. Writes the addresses of the deployed contracts back to
-// the `cfg` struct.
+// the `cfg` struct. Contracts that are not nil are validated.
 func setupContracts(ctx context.Context, cb ethchannel.ContractBackend, deployer accounts.Account, cfg *Config) error {
 	if cfg.Adjudicator == nil {
 		adjudicator, err := ethchannel.DeployAdjudicator(ctx, cb, deployer)
@@ -195,6 +333,11 @@ func setupContracts(ctx context.Context, cb ethchannel.ContractBackend, deployer
 			return errors.WithMessage(err, "deploying adjudicator")
 		}
 		cfg.Adjudicator = &Address{ethwallet.Address(adjudicator)}
+	} else {
+		err := ethchannel.ValidateAdjudicator(ctx, cb, common.Address(cfg.Adjudicator.addr))
+		if err := validateContract(err, "Adjudicator", cfg.Adjudicator); err != nil {
+			return err
+		}
 	}
 	if cfg.AssetHolder == nil {
 		assetHolder, err := ethchannel.DeployETHAssetholder(ctx, cb, common.Address(cfg.Adjudicator.addr), deployer)
@@ -202,14 +345,47 @@ func setupContracts(ctx context.Context, cb ethchannel.ContractBackend, deployer
 			return errors.WithMessage(err, "deploying eth assetHolder")
 		}
 		cfg.AssetHolder = &Address{ethwallet.Address(assetHolder)}
+	} else {
+		// Also checks that the AssetHolder points to the Adjudicator.
+		err := ethchannel.ValidateAssetHolderETH(ctx, cb, common.Address(cfg.AssetHolder.addr), common.Address(cfg.Adjudicator.addr))
+		if err := validateContract(err, "AssetHolder", cfg.AssetHolder); err != nil {
+			return err
+		}
+	}
+	if cfg.Token != nil {
+		assetHolder, err := setupERC20AssetHolder(ctx, cb, deployer, cfg.Adjudicator, cfg.Token, cfg.TokenAssetHolder)
+		if err != nil {
+			return err
+		}
+		cfg.TokenAssetHolder = assetHolder
+		log.WithFields(log.Fields{"token": cfg.Token.ToHex(), "tokenAssetHolder": cfg.TokenAssetHolder.ToHex()}).Debugf("Set token contracts")
 	}
 	// The deployment itself is already logged in the `DeployX` methods
 	log.WithFields(log.Fields{"adjudicator": cfg.Adjudicator.ToHex(), "assetHolder": cfg.AssetHolder.ToHex()}).Debugf("Set contracts")
 	return nil
 }
 
-// OnChainBalance returns the on-chain balance for `address` in Wei.
+// OnChainBalance returns the on-chain balance for `address` in Wei. Use
+// SubscribeOnChainBalance to get notified about changes instead of polling it.
 func (c *Client) OnChainBalance(ctx *Context, address *Address) (*BigInt, error) {
 	bal, err := c.ethClient.BalanceAt(ctx.ctx, common.Address(address.addr), nil)
 	return &BigInt{bal}, err
 }
+
+// OnChainTokenBalance returns the on-chain balance for `address` in the
+// smallest unit of the ERC20 token `token`, e.g. a token that was registered
+// with RegisterTokenAsset. A nil `token` means the Config's Token.
+func (c *Client) OnChainTokenBalance(ctx *Context, token, address *Address) (*BigInt, error) {
+	if token == nil {
+		token = c.cfg.Token
+	}
+	if token == nil {
+		return nil, errors.New("no token configured")
+	}
+	erc20, err := peruntoken.NewERC20(common.Address(token.addr), c.ethClient)
+	if err != nil {
+		return nil, errors.WithMessage(err, "binding token contract")
+	}
+	bal, err := erc20.BalanceOf(&bind.CallOpts{Context: ctx.ctx}, common.Address(address.addr))
+	return &BigInt{bal}, errors.WithMessage(err, "querying token balance")
+}
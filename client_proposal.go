@@ -6,6 +6,8 @@
 package prnm
 
 import (
+	"bytes"
+
 	"github.com/pkg/errors"
 
 	ethwallet "perun.network/go-perun/backend/ethereum/wallet"
@@ -39,21 +41,127 @@ func (c *Client) ProposeChannel(
 	challengeDuration int64,
 	initialBals *BigInts,
 ) (*PaymentChannel, error) {
+	return c.proposeSingleAsset(ctx, c.cfg.AssetHolder, perunID, challengeDuration, initialBals)
+}
+
+// ProposeTokenChannel proposes a new channel that is funded in the configured
+// Token. It otherwise behaves like ProposeChannel.
+func (c *Client) ProposeTokenChannel(
+	ctx *Context,
+	perunID *Address,
+	challengeDuration int64,
+	initialBals *BigInts,
+) (*PaymentChannel, error) {
+	if c.cfg.Token == nil {
+		return nil, errors.New("no token configured")
+	}
+	return c.proposeSingleAsset(ctx, c.cfg.TokenAssetHolder, perunID, challengeDuration, initialBals)
+}
+
+func (c *Client) proposeSingleAsset(
+	ctx *Context,
+	assetHolder *Address,
+	perunID *Address,
+	challengeDuration int64,
+	initialBals *BigInts,
+) (*PaymentChannel, error) {
+	alloc := NewAllocation()
+	if err := alloc.AddAsset(assetHolder, initialBals); err != nil {
+		return nil, err
+	}
+	return c.ProposeMultiAssetChannel(ctx, perunID, challengeDuration, alloc)
+}
+
+// ProposeMultiAssetChannel proposes a new channel with initialAlloc as the
+// initial balances of all assets. All assets must be registered at the Client,
+// see GetAssets. It otherwise behaves like ProposeChannel.
+func (c *Client) ProposeMultiAssetChannel(
+	ctx *Context,
+	perunID *Address,
+	challengeDuration int64,
+	initialAlloc *Allocation,
+) (*PaymentChannel, error) {
+	return c.proposeLedgerChannel(ctx, perunID, challengeDuration, initialAlloc, channel.NoApp(), channel.NoData())
+}
+
+func (c *Client) proposeLedgerChannel(
+	ctx *Context,
+	perunID *Address,
+	challengeDuration int64,
+	initialAlloc *Allocation,
+	app channel.App,
+	initData channel.Data,
+) (*PaymentChannel, error) {
+	prop, err := c.newLedgerProposal(perunID, challengeDuration, initialAlloc, app, initData)
+	if err != nil {
+		return nil, err
+	}
+	return c.proposeChannel(ctx, prop)
+}
+
+func (c *Client) newLedgerProposal(
+	perunID *Address,
+	challengeDuration int64,
+	initialAlloc *Allocation,
+	app channel.App,
+	initData channel.Data,
+) (*client.LedgerChannelProposal, error) {
+	for _, asset := range initialAlloc.assets {
+		if !c.assets.isRegistered(asset) {
+			return nil, errors.Errorf("asset %v is not registered", asset)
+		}
+	}
 	alloc := &channel.Allocation{
-		Assets:   []channel.Asset{(*ethwallet.Address)(&c.cfg.AssetHolder.addr)},
-		Balances: [][]channel.Bal{initialBals.values},
+		Assets:   initialAlloc.assets,
+		Balances: initialAlloc.balances,
 	}
-	prop, err := client.NewLedgerChannelProposal(
+	return client.NewLedgerChannelProposal(
 		uint64(challengeDuration),
 		c.wallet.NewAccount().Address(),
 		alloc,
 		[]wire.Address{c.onChain.Address(), (*ethwallet.Address)(&perunID.addr)},
-		client.WithoutApp())
+		client.WithApp(app, initData))
+}
+
+// ProposeSubChannel proposes a new sub-channel of the `parent` channel with
+// initialAlloc as its initial balances. The sub-channel is funded from the
+// parent channel off-chain, so no on-chain transactions are needed. The
+// assets of initialAlloc must be the same as the assets of the parent.
+//
+// Only the participant with index 0 in the parent channel can propose
+// sub-channels.
+func (c *Client) ProposeSubChannel(
+	ctx *Context,
+	parent *PaymentChannel,
+	challengeDuration int64,
+	initialAlloc *Allocation,
+) (*PaymentChannel, error) {
+	alloc := &channel.Allocation{
+		Assets:   initialAlloc.assets,
+		Balances: initialAlloc.balances,
+	}
+	prop, err := client.NewSubChannelProposal(
+		parent.ch.ID(),
+		uint64(challengeDuration),
+		alloc,
+		client.WithoutApp(),
+		client.WithRandomNonce())
 	if err != nil {
 		return nil, err
 	}
+	return c.proposeChannel(ctx, prop)
+}
+
+// proposeChannel sends `prop` and emits the corresponding events.
+func (c *Client) proposeChannel(ctx *Context, prop client.ChannelProposal) (*PaymentChannel, error) {
+	c.emitProposed(prop, false)
 	_ch, err := c.client.ProposeChannel(ctx.ctx, prop)
-	return &PaymentChannel{_ch}, err
+	if err != nil {
+		c.emitError(nil, err)
+	} else {
+		c.emitChannel(EventFunded, _ch.ID())
+	}
+	return &PaymentChannel{_ch, c}, err
 }
 
 type (
@@ -74,11 +182,46 @@ type (
 
 	// A ChannelProposal describes a proposal to open a new channel.
 	//
-	// The proposer has index 0 and proposee index 1.
+	// The proposer has index 0 and proposee index 1. The Client only passes
+	// proposals to the ProposalHandler that were sent by Peers[0] and that
+	// contain its own PerunID at index 1.
 	ChannelProposal struct {
 		Peer              *Address // The peer proposing the channel.
 		ChallengeDuration int64    // Proposed challenge duration in case of disputes, in seconds.
-		InitBals          *BigInts // Initial channel balances.
+		InitBals          *BigInts // Initial channel balances of the first asset.
+		// AssetHolder of the first asset, e.g. the Config's AssetHolder or
+		// TokenAssetHolder.
+		AssetHolder *Address
+		// Initial channel balances of all assets.
+		InitAlloc *Allocation
+		// ID of the parent channel if this is a sub-channel proposal,
+		// otherwise nil.
+		ParentID []byte
+		// Definition of the channel's app or nil for payment channels.
+		AppDef *Address
+		// Encoded initial app data. Empty for payment channels.
+		InitData []byte
+		// Alias of the proposing peer or an empty string if it is unknown.
+		PeerAlias string
+		// Unique identifier of the proposal.
+		ProposalID []byte
+		// The proposer's share of the channel nonce.
+		NonceShare []byte
+		// Known channel participant addresses. Only contains the proposer's
+		// participant for ledger channels since the proposee chooses its own
+		// participant on Accept. Contains all participants of the parent for
+		// sub-channels.
+		Participants *Addresses
+		// PerunIDs of all peers of the channel.
+		Peers *Addresses
+		// Amounts that every participant has to fund for each asset. Differs
+		// from InitAlloc if the peers agreed on a different funding.
+		FundingAgreement *Allocation
+		// ID of the channel that the proposed channel replaces if the peer
+		// rolls it over, otherwise nil. See PaymentChannel.Rollover.
+		// Accept settles and closes the replaced channel once the peer
+		// finalized it, so the finalizing update must be accepted.
+		RolloverOf []byte
 	}
 
 	// A ProposalResponder lets the user respond to a channel proposal. If the
@@ -87,8 +230,10 @@ type (
 	// causes a panic.
 	ProposalResponder struct {
 		c *Client // back-reference for account generation in Accept
-		p client.LedgerChannelProposal
+		p client.ChannelProposal
 		r *client.ProposalResponder // wrapped ProposalResponder
+
+		rolloverOf *channel.ID // predecessor of the proposed channel, if any
 	}
 )
 
@@ -96,32 +241,67 @@ type (
 // passed types from the go-perun/client package into their local conterparts
 // and then calling the prnm.ProposalHandler.
 func (h *proposalHandler) HandleProposal(_prop client.ChannelProposal, _resp *client.ProposalResponder) {
-	ledgerProp, ok := _prop.(*client.LedgerChannelProposal)
-	if !ok {
-		// We can not reject here since there is no context available.
-		log.Warn("Ignored sub-channel proposal")
-		return
-	}
-	if err := checkProp(*ledgerProp); err != nil {
+	propID := _prop.ProposalID()
+	if err := h.c.checkProp(_prop); err != nil {
 		log.Warn("Ignored proposal: ", err)
 		return
 	}
-	// Security Note: we don't check the remote nonce or channel participant. If
-	// this code were to evolve to production grade, this needs to be taken care
-	// of. In this case, at least the Nonce should be part of the ChannelProposal
-	// struct, as is the case for the client.ChannelProposal.
+	base := _prop.Base()
 	prop := &ChannelProposal{
-		Peer:              &Address{*(ledgerProp.Peers[0]).(*ethwallet.Address)},
-		ChallengeDuration: int64(ledgerProp.ChallengeDuration),
-		InitBals:          &BigInts{ledgerProp.InitBals.Balances[0]},
+		ChallengeDuration: int64(base.ChallengeDuration),
+		InitBals:          &BigInts{base.InitBals.Balances[0]},
+		AssetHolder:       &Address{*base.InitBals.Assets[0].(*ethwallet.Address)},
+		InitAlloc:         &Allocation{assets: base.InitBals.Assets, balances: base.InitBals.Balances},
+		ProposalID:        propID[:],
+		NonceShare:        append([]byte(nil), base.NonceShare[:]...),
+		FundingAgreement:  &Allocation{assets: base.InitBals.Assets, balances: base.FundingAgreement},
+	}
+	if !channel.IsNoApp(base.App) {
+		prop.AppDef = &Address{*base.App.Def().(*ethwallet.Address)}
+		var buf bytes.Buffer
+		if err := base.InitData.Encode(&buf); err != nil {
+			log.Warn("Ignored proposal: encoding app data: ", err)
+			return
+		}
+		prop.InitData = buf.Bytes()
+	}
+	var peers []wire.Address
+	switch p := _prop.(type) {
+	case *client.LedgerChannelProposal:
+		peers = p.Peers
+		prop.Participants = &Addresses{values: []ethwallet.Address{*p.Participant.(*ethwallet.Address)}}
+	case *client.SubChannelProposal:
+		parent, err := h.c.client.Channel(p.Parent)
+		if err != nil {
+			log.Warn("Ignored sub-channel proposal: ", err)
+			return
+		}
+		// Sub-channels are always proposed by the parent's proposer.
+		peers = parent.Peers()
+		prop.Participants = (&Params{parent.Params()}).GetParts()
+		prop.ParentID = p.Parent[:]
+	}
+	prop.Peers = &Addresses{values: make([]ethwallet.Address, len(peers))}
+	for i, p := range peers {
+		prop.Peers.values[i] = *p.(*ethwallet.Address)
+	}
+	prop.Peer = &Address{prop.Peers.values[0]}
+	prop.PeerAlias = h.c.addrBook.alias(prop.Peer.addr)
+	h.c.emitProposed(_prop, true)
+	resp := &ProposalResponder{c: h.c, p: _prop, r: _resp}
+	// go-perun only passes two-party proposals that were sent by peers[0].
+	if pred, ok := h.c.rollovers.takePending(propID, peers[0]); ok {
+		prop.RolloverOf, resp.rolloverOf = pred[:], &pred
 	}
-	resp := &ProposalResponder{c: h.c, p: *ledgerProp, r: _resp}
 	h.h.HandleProposal(prop, resp)
 }
 
 // Accept lets the user signal that they want to accept the channel proposal.
 // Returns the newly created channel controller if the channel was successfully
 // created and funded. Panics if the proposal was already accepted or rejected.
+// For rollovers, the replaced channel is settled before the new channel is
+// funded, see ChannelProposal.RolloverOf. If funding fails afterwards, a
+// RolloverError is returned.
 //
 // After the channel got successfully created, the user is required to start the
 // update handler with PaymentChannel.HandleUpdates(UpdateHandler) and to start
@@ -132,11 +312,51 @@ func (h *proposalHandler) HandleProposal(_prop client.ChannelProposal, _resp *cl
 // ChallengeDuration has passed (at least for real blockchain backends with wall
 // time), or the channel cannot be settled if a peer times out funding.
 func (r *ProposalResponder) Accept(ctx *Context) (*PaymentChannel, error) {
-	// Generate new account as channel participant.
-	account := r.c.wallet.NewAccount().Address()
-	acceptor := r.p.Accept(account, client.WithRandomNonce())
+	var (
+		acceptor client.ChannelProposalAccept
+		pred     *PaymentChannel // replaced channel of a rollover
+		settled  bool
+	)
+	switch p := r.p.(type) {
+	case *client.LedgerChannelProposal:
+		// Generate new account as channel participant.
+		account := r.c.wallet.NewAccount().Address()
+		acceptor = p.Accept(account, client.WithRandomNonce())
+		if r.rolloverOf == nil {
+			break
+		}
+		ch, ok := r.c.chans.get(*r.rolloverOf)
+		if !ok {
+			return nil, errors.New("replaced channel of rollover not found")
+		}
+		// Our funds of the replaced channel are deposited into the new one,
+		// so it is settled before the new channel is funded, see Rollover.
+		pred = &PaymentChannel{ch, r.c}
+		cancel := r.c.assets.onFunding(*account.(*ethwallet.Address), func() error {
+			if err := pred.settleAcceptedRollover(ctx); err != nil {
+				return err
+			}
+			settled = true
+			return nil
+		})
+		defer cancel()
+	case *client.SubChannelProposal:
+		// Sub-channels use the participants of their parent.
+		acceptor = p.Accept(client.WithRandomNonce())
+	}
 	ch, err := r.r.Accept(ctx.ctx, acceptor)
-	return &PaymentChannel{ch}, err
+	if err != nil {
+		r.c.emitError(nil, err)
+		if settled {
+			return nil, &RolloverError{Settled: pred, cause: err}
+		}
+		return &PaymentChannel{ch, r.c}, err
+	}
+	r.c.emitChannel(EventFunded, ch.ID())
+	if r.rolloverOf != nil {
+		err = r.c.rollovers.link(ch.ID(), *r.rolloverOf)
+	}
+	return &PaymentChannel{ch, r.c}, err
 }
 
 // Reject lets the user signal that they reject the channel proposal.
@@ -146,12 +366,22 @@ func (r *ProposalResponder) Reject(ctx *Context, reason string) error {
 	return r.r.Reject(ctx.ctx, reason)
 }
 
-func checkProp(prop client.LedgerChannelProposal) error {
-	switch {
-	case len(prop.InitBals.Assets) != 1:
-		return errors.New("only single-asset channels are supported")
-	case !channel.IsNoApp(prop.App):
-		return errors.New("only payment channels are supported")
+func (c *Client) checkProp(_prop client.ChannelProposal) error {
+	switch _prop.(type) {
+	case *client.LedgerChannelProposal, *client.SubChannelProposal:
+	default:
+		return errors.Errorf("unsupported proposal type %T", _prop)
+	}
+	prop := _prop.Base()
+	if !channel.IsNoApp(prop.App) {
+		if _, err := c.apps.get(prop.App.Def()); err != nil {
+			return err
+		}
+	}
+	for _, asset := range prop.InitBals.Assets {
+		if !c.assets.isRegistered(asset) {
+			return errors.Errorf("unknown asset %v", asset)
+		}
 	}
 	return nil
 }
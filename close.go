@@ -0,0 +1,81 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"github.com/pkg/errors"
+
+	"perun.network/go-perun/log"
+)
+
+// Stages of CloseCooperatively that are reported to the CloseProgressHandler.
+const (
+	// CloseFinalizing is reported before the final update is proposed.
+	CloseFinalizing = iota
+	// CloseFinalized is reported once the channel state is final.
+	CloseFinalized
+	// CloseDisputing is reported if the peer did not accept the final update
+	// and the latest state is registered on-chain instead. Settling then
+	// takes at least one challenge duration.
+	CloseDisputing
+	// CloseSettling is reported before the funds are withdrawn.
+	CloseSettling
+	// CloseClosed is reported once the funds are withdrawn and the channel is
+	// closed.
+	CloseClosed
+)
+
+// CloseProgressHandler is notified about the progress of CloseCooperatively.
+type CloseProgressHandler interface {
+	OnCloseProgress(stage int)
+}
+
+// CloseCooperatively finalizes, settles and closes the channel in one call.
+// The peer has `timeout` seconds to accept the final update. If it does not,
+// the latest state is registered on-chain and the channel is settled after
+// the challenge duration instead, so `ctx` must allow for that.
+// If the peer already finalized the channel, the optimized secondary settle
+// protocol is used. The peer is expected to settle the channel once it is
+// concluded, see ConcludedEventHandler.
+// The progress is reported to `h`, which can be nil.
+// Sub-channels can only be closed if the peer accepts the final update.
+func (c *PaymentChannel) CloseCooperatively(ctx *Context, timeout int, h CloseProgressHandler) error {
+	report := func(stage int) {
+		if h != nil {
+			h.OnCloseProgress(stage)
+		}
+	}
+
+	// The peer finalized the channel and already settles it.
+	secondary := c.ch.State().IsFinal
+	if !secondary {
+		report(CloseFinalizing)
+		finCtx := ctx.WithTimeout(timeout)
+		err := c.Finalize(finCtx)
+		finCtx.Cancel()
+		switch {
+		case err == nil:
+			report(CloseFinalized)
+		case c.ch.IsSubChannel():
+			return errors.WithMessage(err, "finalizing sub-channel")
+		default:
+			log.WithField("channel", c.ch.ID()).WithError(err).Warn("Finalizing failed, disputing")
+			report(CloseDisputing)
+		}
+	} else {
+		report(CloseFinalized)
+	}
+
+	report(CloseSettling)
+	if err := c.Settle(ctx, secondary); err != nil {
+		return errors.WithMessage(err, "settling")
+	}
+	if err := c.Close(); err != nil {
+		return errors.WithMessage(err, "closing")
+	}
+	report(CloseClosed)
+	return nil
+}
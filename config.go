@@ -14,19 +14,64 @@ import (
 
 // Config complete configuration needed to operate the Client.
 type Config struct {
-	// Name to be used in state channels.
-	Alias   string
-	Address *Address // OnChain address and PerunID.
+	// Name to be used in state channels. It is announced to peers if
+	// ExchangeAlias is set. At most 64 bytes long.
+	Alias string
+	// Optional 32 byte hash of an avatar image that is sent to peers along
+	// with the Alias.
+	AvatarHash []byte
+	// Whether to announce the Alias to every peer that we communicate with.
+	// Only enable this if all peers support alias messages, older peers drop
+	// the connection when receiving one. Aliases of other peers are received
+	// regardless of this setting.
+	ExchangeAlias bool
+	Address       *Address // OnChain address and PerunID.
 	// On-chain addresses of the Adjudicator and AssetHolder Contract.
 	// In case any of them is nil, the Client will deploy the contract in its
 	// NewClient constructor.
 	Adjudicator, AssetHolder *Address
-	ETHNodeURL               string // URL of the ETH node. Example: ws://127.0.0.1:8545
-	IP                       string // Ip to listen on.
-	Port                     uint16 // Port to listen on.
+	// On-chain address of an ERC20 token. If it is not nil, the Client also
+	// supports channels that are funded in this token.
+	Token *Address
+	// On-chain address of the ERC20 AssetHolder contract of the Token.
+	// In case it is nil and the Token is not nil, the Client will deploy the
+	// contract in its NewClient constructor.
+	TokenAssetHolder *Address
+	ETHNodeURL       string // URL of the ETH node. Example: ws://127.0.0.1:8545
+	// ChainID of the Ethereum network. Used for signing transactions and
+	// checked against the chain ID reported by the ETH node in NewClient.
+	ChainID int64
+	IP      string // Ip to listen on.
+	Port    uint16 // Port to listen on.
+	// Whether incoming channel updates are checked before they are passed to
+	// the UpdateHandler. Updates that increase the version by more than one,
+	// change the total of an asset or decrease our balance without us being
+	// the actor are then rejected automatically.
+	ValidateUpdates bool
+	// On-chain address that receives our funds when a channel is settled. In
+	// case it is nil, the funds are withdrawn to the Address. Can be
+	// overridden per channel with PaymentChannel.SettleTo.
+	WithdrawalReceiver *Address
+	// How the gas price of transactions is chosen, see GasPriceSuggested,
+	// GasPriceFixed and GasPriceCapped.
+	GasPriceStrategy int
+	// Gas price in Wei for GasPriceFixed and the maximal gas price for
+	// GasPriceCapped.
+	GasPrice *BigInt
+	// Factor for the suggested gas price of GasPriceSuggested and
+	// GasPriceCapped. 0 means 1.
+	GasPriceMultiplier float64
+	// Maximal fee in Wei that a single transaction may cost. It is checked
+	// against the gas limit of the transaction, so the actual fee is usually
+	// lower. Transactions above it fail. nil means unlimited.
+	MaxFee *BigInt
 }
 
-// NewConfig creates a new configuration
+// DefaultChainID is the chain ID of a local ganache-cli node and used by
+// NewConfig. Set Config.ChainID to operate on other networks.
+const DefaultChainID = 1337
+
+// NewConfig creates a new configuration with the DefaultChainID.
 func NewConfig(alias string, address, adjudicator, assetHolder *Address, ETHNodeURL, ip string, port int) *Config {
 	return &Config{
 		Alias:       alias,
@@ -34,6 +79,7 @@ func NewConfig(alias string, address, adjudicator, assetHolder *Address, ETHNode
 		Adjudicator: adjudicator,
 		AssetHolder: assetHolder,
 		ETHNodeURL:  ETHNodeURL,
+		ChainID:     DefaultChainID,
 		IP:          ip,
 		Port:        uint16(port),
 	}
@@ -0,0 +1,211 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"sync"
+
+	ethchannel "perun.network/go-perun/backend/ethereum/channel"
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/client"
+	"perun.network/go-perun/log"
+)
+
+// maxQueuedEvents is the number of events that are queued for a slow
+// listener before the oldest ones are dropped.
+const maxQueuedEvents = 1024
+
+// Types of a ChannelEvent.
+const (
+	// EventProposed is emitted for every sent or received valid proposal.
+	EventProposed = iota
+	// EventFunded is emitted once a proposed channel is funded.
+	EventFunded
+	// EventUpdated is emitted for every sent or received accepted update.
+	EventUpdated
+	// EventRegistered is emitted when a state was registered on-chain, which
+	// starts a dispute.
+	EventRegistered
+	// EventProgressed is emitted when an app channel was progressed on-chain.
+	EventProgressed
+	// EventConcluded is emitted when a channel was concluded on-chain.
+	EventConcluded
+	// EventWithdrawn is emitted when our funds of a channel were withdrawn.
+	EventWithdrawn
+	// EventClosed is emitted when a channel was closed.
+	EventClosed
+	// EventError is emitted when an operation on a channel failed.
+	EventError
+)
+
+type (
+	// ChannelEvent describes something that happened to a channel. Only the
+	// fields that are documented for its Type are set.
+	ChannelEvent struct {
+		Type int // One of the Event* constants.
+		// ID of the channel. Not set for EventProposed and for errors that
+		// happened before the channel was funded.
+		ChannelID []byte
+		// ID of the proposal for EventProposed.
+		ProposalID []byte
+		// Whether the peer proposed the channel for EventProposed.
+		Incoming bool
+		// Version of the state for EventUpdated, EventRegistered,
+		// EventProgressed and EventConcluded.
+		Version int64
+		// Direction of the payment for EventUpdated, see DirectionNone.
+		Direction int
+		// Unix time in seconds at which the current dispute phase ends for
+		// EventRegistered and EventProgressed. 0 if it is unknown.
+		Timeout int64
+		// Error message for EventError.
+		Err string
+	}
+
+	// ChannelEventListener receives the events of all channels of a Client.
+	ChannelEventListener interface {
+		OnChannelEvent(*ChannelEvent)
+	}
+
+	// eventQueue passes events to the ChannelEventListener in the order in
+	// which they were emitted. The listener is called from a dedicated
+	// routine so that it can call into the Client without deadlocking.
+	eventQueue struct {
+		mu       sync.Mutex
+		cond     *sync.Cond
+		events   []*ChannelEvent
+		listener ChannelEventListener
+		closed   bool
+	}
+)
+
+func newEventQueue() *eventQueue {
+	q := new(eventQueue)
+	q.cond = sync.NewCond(&q.mu)
+	go q.run()
+	return q
+}
+
+func (q *eventQueue) run() {
+	for {
+		q.mu.Lock()
+		for len(q.events) == 0 && !q.closed {
+			q.cond.Wait()
+		}
+		if q.closed {
+			q.mu.Unlock()
+			return
+		}
+		e, l := q.events[0], q.listener
+		q.events = q.events[1:]
+		q.mu.Unlock()
+
+		if l != nil {
+			l.OnChannelEvent(e)
+		}
+	}
+}
+
+// emit queues `e` if a listener is set. The oldest event is dropped if
+// maxQueuedEvents are queued already.
+func (q *eventQueue) emit(e *ChannelEvent) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.listener == nil || q.closed {
+		return
+	}
+	if len(q.events) >= maxQueuedEvents {
+		log.WithField("type", q.events[0].Type).Warn("Event queue full, dropping oldest event")
+		q.events[0] = nil
+		q.events = q.events[1:]
+	}
+	q.events = append(q.events, e)
+	q.cond.Signal()
+}
+
+func (q *eventQueue) setListener(l ChannelEventListener) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.listener = l
+}
+
+// close stops the routine. Queued events are dropped.
+func (q *eventQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.cond.Signal()
+}
+
+// SetChannelEventListener sets the listener that receives the events of all
+// channels. Only one listener can be set at a time, repeated calls replace
+// it. nil removes the listener.
+// The listener is called from a single routine, so events arrive in order and
+// a slow listener delays all further events. If a listener falls behind by
+// more than 1024 events, the oldest events are dropped.
+// Adjudicator events are only emitted for channels that are watched, see
+// PaymentChannel.Watch.
+func (c *Client) SetChannelEventListener(l ChannelEventListener) {
+	c.events.setListener(l)
+}
+
+func (c *Client) emitProposed(prop client.ChannelProposal, incoming bool) {
+	id := prop.ProposalID()
+	c.events.emit(&ChannelEvent{Type: EventProposed, ProposalID: id[:], Incoming: incoming})
+}
+
+// emitChannel emits an event of type `typ` for channel `id`.
+func (c *Client) emitChannel(typ int, id channel.ID) {
+	c.events.emit(&ChannelEvent{Type: typ, ChannelID: id[:]})
+}
+
+// emitError emits an EventError for channel `ch`, which can be nil.
+func (c *Client) emitError(ch *client.Channel, err error) {
+	e := &ChannelEvent{Type: EventError, Err: err.Error()}
+	if ch != nil {
+		id := ch.ID()
+		e.ChannelID = id[:]
+	}
+	c.events.emit(e)
+}
+
+func (c *Client) emitUpdated(id channel.ID, e historyEntry) {
+	c.events.emit(&ChannelEvent{
+		Type:      EventUpdated,
+		ChannelID: id[:],
+		Version:   int64(e.Version),
+		Direction: e.direction(),
+	})
+}
+
+// emitAdjudicatorEvent emits the event that corresponds to `e`.
+func (c *Client) emitAdjudicatorEvent(e channel.AdjudicatorEvent) {
+	if ev := adjudicatorEvent(e); ev != nil {
+		c.events.emit(ev)
+	}
+}
+
+// adjudicatorEvent converts `e` into a ChannelEvent. Returns nil for unknown
+// event types.
+func adjudicatorEvent(e channel.AdjudicatorEvent) *ChannelEvent {
+	var typ int
+	switch e.(type) {
+	case *channel.RegisteredEvent:
+		typ = EventRegistered
+	case *channel.ProgressedEvent:
+		typ = EventProgressed
+	case *channel.ConcludedEvent:
+		typ = EventConcluded
+	default:
+		return nil
+	}
+	id := e.ID()
+	ev := &ChannelEvent{Type: typ, ChannelID: id[:], Version: int64(e.Version())}
+	if t, ok := e.Timeout().(*ethchannel.BlockTimeout); ok && typ != EventConcluded {
+		ev.Timeout = int64(t.Time)
+	}
+	return ev
+}
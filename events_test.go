@@ -0,0 +1,56 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingListener passes all events to a channel and blocks until they are
+// received.
+type blockingListener chan *ChannelEvent
+
+func (l blockingListener) OnChannelEvent(e *ChannelEvent) { l <- e }
+
+func TestEventQueue_Bounded(t *testing.T) {
+	q := newEventQueue()
+	defer q.close()
+	l := make(blockingListener)
+	q.setListener(l)
+
+	// The routine takes the first event and blocks in the listener, all
+	// further events are queued.
+	q.emit(&ChannelEvent{Version: 0})
+	require.Eventually(t, func() bool {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		return len(q.events) == 0
+	}, testTimeout, 10*time.Millisecond)
+	for v := 1; v <= maxQueuedEvents+10; v++ {
+		q.emit(&ChannelEvent{Version: int64(v)})
+	}
+	next := func() *ChannelEvent {
+		select {
+		case e := <-l:
+			return e
+		case <-time.After(testTimeout):
+			t.Fatal("event was not passed to the listener")
+			return nil
+		}
+	}
+
+	q.mu.Lock()
+	assert.Len(t, q.events, maxQueuedEvents)
+	q.mu.Unlock()
+	// The oldest queued events were dropped, the newest are passed in order.
+	assert.Equal(t, int64(0), next().Version)
+	assert.Equal(t, int64(11), next().Version)
+	assert.Equal(t, int64(12), next().Version)
+}
@@ -0,0 +1,204 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/pkg/errors"
+
+	ethchannel "perun.network/go-perun/backend/ethereum/channel"
+	"perun.network/go-perun/backend/ethereum/wallet/keystore"
+)
+
+// Gas price strategies, see Config.GasPriceStrategy.
+const (
+	// GasPriceSuggested uses the gas price that the ETH node suggests,
+	// multiplied by the Config's GasPriceMultiplier.
+	GasPriceSuggested = iota
+	// GasPriceFixed always uses the Config's GasPrice.
+	GasPriceFixed
+	// GasPriceCapped works like GasPriceSuggested but never exceeds the
+	// Config's GasPrice.
+	GasPriceCapped
+)
+
+type (
+	// gasPolicy decides the gas price of all transactions. It is copied from
+	// the Config in NewClient.
+	gasPolicy struct {
+		strategy   int
+		price      *big.Int   // Fixed price or cap, nil for GasPriceSuggested.
+		multiplier *big.Float // nil means 1.
+		maxFee     *big.Int   // nil means unlimited.
+	}
+
+	// gasTransactor is a keystore.Transactor that applies a gasPolicy. The
+	// gas price is adjusted when the transaction is signed, at which point
+	// go-ethereum already filled in the suggested price.
+	gasTransactor struct {
+		*keystore.Transactor
+		policy gasPolicy
+	}
+)
+
+func newGasPolicy(cfg *Config) (gasPolicy, error) {
+	p := gasPolicy{strategy: cfg.GasPriceStrategy}
+	switch cfg.GasPriceStrategy {
+	case GasPriceSuggested:
+	case GasPriceFixed, GasPriceCapped:
+		if cfg.GasPrice == nil || cfg.GasPrice.i.Sign() <= 0 {
+			return p, errors.New("gas price strategy needs a positive GasPrice")
+		}
+		p.price = new(big.Int).Set(cfg.GasPrice.i)
+	default:
+		return p, errors.Errorf("unknown gas price strategy %d", cfg.GasPriceStrategy)
+	}
+	if cfg.GasPriceMultiplier < 0 {
+		return p, errors.New("gas price multiplier must not be negative")
+	} else if cfg.GasPriceMultiplier != 0 && cfg.GasPriceMultiplier != 1 {
+		p.multiplier = big.NewFloat(cfg.GasPriceMultiplier)
+	}
+	if cfg.MaxFee != nil {
+		p.maxFee = new(big.Int).Set(cfg.MaxFee.i)
+	}
+	return p, nil
+}
+
+// apply returns the gas price that the policy chooses when the node suggests
+// `suggested`.
+func (p gasPolicy) apply(suggested *big.Int) *big.Int {
+	if p.strategy == GasPriceFixed {
+		return new(big.Int).Set(p.price)
+	}
+	price := new(big.Int).Set(suggested)
+	if p.multiplier != nil {
+		price, _ = new(big.Float).Mul(new(big.Float).SetInt(suggested), p.multiplier).Int(nil)
+	}
+	if p.strategy == GasPriceCapped && price.Cmp(p.price) > 0 {
+		price.Set(p.price)
+	}
+	return price
+}
+
+// gasPrice returns the gas price that the policy currently chooses.
+func (p gasPolicy) gasPrice(ctx context.Context, cb bind.ContractTransactor) (*big.Int, error) {
+	if p.strategy == GasPriceFixed {
+		return p.apply(nil), nil
+	}
+	suggested, err := cb.SuggestGasPrice(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "querying gas price")
+	}
+	return p.apply(suggested), nil
+}
+
+// checkFee returns an error if `gas` at `price` would exceed the MaxFee.
+func (p gasPolicy) checkFee(gas uint64, price *big.Int) error {
+	if p.maxFee == nil {
+		return nil
+	}
+	if fee := new(big.Int).Mul(new(big.Int).SetUint64(gas), price); fee.Cmp(p.maxFee) > 0 {
+		return errors.Errorf("transaction fee %v exceeds MaxFee %v", fee, p.maxFee)
+	}
+	return nil
+}
+
+func newGasTransactor(tr *keystore.Transactor, p gasPolicy) *gasTransactor {
+	return &gasTransactor{Transactor: tr, policy: p}
+}
+
+// NewTransactor returns TransactOpts for `account` whose signer applies the
+// gas policy. Transactions that would exceed the MaxFee are not signed and
+// thereby not sent.
+func (t *gasTransactor) NewTransactor(account accounts.Account) (*bind.TransactOpts, error) {
+	opts, err := t.Transactor.NewTransactor(account)
+	if err != nil {
+		return nil, err
+	}
+	if t.policy.strategy == GasPriceFixed {
+		opts.GasPrice = t.policy.apply(nil)
+	}
+	sign := opts.Signer
+	opts.Signer = func(addr common.Address, tx *types.Transaction) (*types.Transaction, error) {
+		if price := t.policy.apply(tx.GasPrice()); price.Cmp(tx.GasPrice()) != 0 {
+			tx = withGasPrice(tx, price)
+		}
+		if err := t.policy.checkFee(tx.Gas(), tx.GasPrice()); err != nil {
+			return nil, err
+		}
+		return sign(addr, tx)
+	}
+	return opts, nil
+}
+
+// withGasPrice returns a copy of the unsigned transaction `tx` with the gas
+// price `price`.
+func withGasPrice(tx *types.Transaction, price *big.Int) *types.Transaction {
+	if tx.To() == nil {
+		return types.NewContractCreation(tx.Nonce(), tx.Value(), tx.Gas(), price, tx.Data())
+	}
+	return types.NewTransaction(tx.Nonce(), *tx.To(), tx.Value(), tx.Gas(), price, tx.Data())
+}
+
+// estimateFee returns the maximal fee of transactions with `gas` in total at
+// the current gas price.
+func (c *Client) estimateFee(ctx *Context, gas uint64) (*BigInt, error) {
+	price, err := c.gas.gasPrice(ctx.ctx, c.ethClient)
+	if err != nil {
+		return nil, err
+	}
+	return &BigInt{new(big.Int).Mul(new(big.Int).SetUint64(gas), price)}, nil
+}
+
+// EstimateProposalFee returns the maximal on-chain fee in Wei that funding a
+// proposed channel costs us at the current gas price. `assetHolder` is the
+// AssetHolder that funds the channel, nil means the Config's AssetHolder.
+// The estimate is an upper bound since it uses the gas limit of the deposit
+// transactions.
+func (c *Client) EstimateProposalFee(ctx *Context, assetHolder *Address) (*BigInt, error) {
+	if assetHolder == nil {
+		assetHolder = c.cfg.AssetHolder
+	}
+	dep, ok := c.assets.depositor(assetHolder.addr)
+	if !ok {
+		return nil, errors.Errorf("asset %v is not registered", assetHolder.addr.String())
+	}
+	var gas uint64
+	switch dep.(type) {
+	case *ethchannel.ETHDepositor:
+		gas = ethchannel.ETHDepositorGasLimit
+	case *ethchannel.ERC20Depositor:
+		gas = ethchannel.ERC20DepositorTXGasLimit
+	default:
+		return nil, errors.Errorf("unknown depositor %T", dep)
+	}
+	return c.estimateFee(ctx, gas*uint64(dep.NumTX()))
+}
+
+// EstimateSettleFee returns the maximal on-chain fee in Wei that settling the
+// channel costs us at the current gas price: one transaction to conclude the
+// channel, one withdrawal per asset and, if the channel is not final, one
+// transaction to register its state first. Sub-channels are settled off-chain
+// and cost nothing.
+// The estimate is an upper bound since it uses the gas limit of the
+// transactions.
+func (c *PaymentChannel) EstimateSettleFee(ctx *Context) (*BigInt, error) {
+	if c.ch.IsSubChannel() {
+		return &BigInt{new(big.Int)}, nil
+	}
+	state := c.ch.State()
+	txs := uint64(1 + len(state.Assets))
+	if !state.IsFinal {
+		txs++ // register
+	}
+	return c.c.estimateFee(ctx, txs*ethchannel.GasLimit)
+}
@@ -0,0 +1,45 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	ethchannel "perun.network/go-perun/backend/ethereum/channel"
+	"perun.network/go-perun/channel"
+	pkgtest "perun.network/go-perun/pkg/test"
+)
+
+func TestPaymentChannel_EstimateSettleFee(t *testing.T) {
+	rng := pkgtest.Prng(t)
+	tc := newTestChannel(t, rng, &Config{})
+	tc.bob.gas = gasPolicy{strategy: GasPriceFixed, price: big.NewInt(2)}
+	c := &PaymentChannel{tc.alice, tc.bob}
+	ctx := ContextWithTimeout(int(testTimeout.Seconds()))
+	defer ctx.Cancel()
+
+	estimate := func() int64 {
+		fee, err := c.EstimateSettleFee(ctx)
+		require.NoError(t, err)
+		return fee.i.Int64()
+	}
+	// Register, conclude and one withdrawal.
+	assert.Equal(t, int64(3*2*ethchannel.GasLimit), estimate())
+
+	updateCtx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	require.NoError(t, tc.alice.UpdateBy(updateCtx, func(s *channel.State) error {
+		s.IsFinal = true
+		return nil
+	}))
+	// Conclude and one withdrawal.
+	assert.Equal(t, int64(2*2*ethchannel.GasLimit), estimate())
+}
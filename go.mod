@@ -6,5 +6,6 @@ require (
 	github.com/ethereum/go-ethereum v1.9.25
 	github.com/pkg/errors v0.9.1
 	github.com/sirupsen/logrus v1.7.0
+	github.com/stretchr/testify v1.6.1
 	perun.network/go-perun v0.6.1-0.20210218151849-cf9279c99f73
 )
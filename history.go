@@ -0,0 +1,265 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/log"
+	"perun.network/go-perun/pkg/sortedkv"
+)
+
+const (
+	// historyPrefix is the prefix of the payment history table in the database.
+	historyPrefix = "prnm:History:"
+	// maxInt is the largest int, which has 32 bits on some mobile platforms.
+	maxInt = int(^uint(0) >> 1)
+)
+
+// Directions of a Payment.
+const (
+	// DirectionNone marks updates that did not change our balances, for
+	// example the finalization of a channel.
+	DirectionNone = iota
+	// DirectionIncoming marks payments to us.
+	DirectionIncoming
+	// DirectionOutgoing marks payments by us.
+	DirectionOutgoing
+)
+
+type (
+	// Payment is an entry of the payment history of a channel. It describes an
+	// accepted update from the previous version to Version.
+	Payment struct {
+		ChannelID []byte // Differs from the channel's ID for rolled over channels.
+		Version   int64
+		Timestamp int64    // Unix time in seconds when the update was accepted.
+		Deltas    *BigInts // Change of our balance, one per asset.
+		Direction int      // See DirectionNone, DirectionIncoming and DirectionOutgoing.
+		Memo      string   // Memo of the payment, see PaymentChannel.SendWithMemo.
+	}
+
+	// Payments is a slice of Payment's
+	Payments struct {
+		values []Payment
+	}
+
+	// historyEntry is the database representation of a Payment.
+	historyEntry struct {
+		Version   uint64     `json:"version"`
+		Timestamp int64      `json:"timestamp"`
+		Deltas    []*big.Int `json:"deltas"`
+	}
+
+	// chanHistoryEntry is a historyEntry of channel `id`.
+	chanHistoryEntry struct {
+		id channel.ID
+		historyEntry
+	}
+
+	// historyStore records the payment history of all channels. Entries are
+	// kept in memory until persistence is enabled and stored in the database
+	// afterwards.
+	historyStore struct {
+		mu      sync.Mutex
+		entries map[channel.ID][]historyEntry
+		db      sortedkv.Database // nil until persistence is enabled.
+	}
+)
+
+func newHistoryStore() *historyStore {
+	return &historyStore{entries: make(map[channel.ID][]historyEntry)}
+}
+
+// enablePersistence moves all entries into `db`.
+func (h *historyStore) enablePersistence(db sortedkv.Database) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.db = sortedkv.NewTable(db, historyPrefix)
+	for id, entries := range h.entries {
+		for _, e := range entries {
+			if err := h.persist(id, e); err != nil {
+				return err
+			}
+		}
+		delete(h.entries, id)
+	}
+	return nil
+}
+
+// record adds the update from `from` to `to` of channel `id` to the history
+// and returns its entry. `idx` is our index in the channel. Updates that only
+// move funds into or out of sub-channels, or only change the app data, are no
+// payments and are not added.
+func (h *historyStore) record(id channel.ID, idx channel.Index, from, to *channel.State) historyEntry {
+	e := historyEntry{
+		Version:   to.Version,
+		Timestamp: time.Now().Unix(),
+		Deltas:    make([]*big.Int, len(to.Balances)),
+	}
+	for a := range to.Balances {
+		e.Deltas[a] = new(big.Int).Sub(to.Balances[a][idx], from.Balances[a][idx])
+	}
+	if !isPayment(from, to, e) {
+		return e
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.db == nil {
+		h.entries[id] = append(h.entries[id], e)
+		return e
+	}
+	if err := h.persist(id, e); err != nil {
+		log.WithField("channel", id).WithError(err).Warn("Recording payment failed")
+	}
+	return e
+}
+
+// isPayment returns whether the update from `from` to `to` with entry `e`
+// belongs into the history. Funding and withdrawing sub-channels changes the
+// locked sub-allocations and the payments are recorded in the sub-channels'
+// own history instead.
+func isPayment(from, to *channel.State, e historyEntry) bool {
+	if !channel.SubAllocsEqual(from.Locked, to.Locked) {
+		return false
+	}
+	return e.direction() != DirectionNone || to.IsFinal
+}
+
+// list returns at most `max` entries of channel `id`, newest first.
+func (h *historyStore) list(id channel.ID, max int) ([]historyEntry, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.db == nil {
+		entries := append([]historyEntry(nil), h.entries[id]...)
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Version > entries[j].Version })
+		if len(entries) > max {
+			entries = entries[:max]
+		}
+		return entries, nil
+	}
+
+	var entries []historyEntry
+	it := sortedkv.NewTable(h.db, hex.EncodeToString(id[:])+":").NewIterator()
+	// Keys are sorted newest first, see persist.
+	for len(entries) < max && it.Next() {
+		var e historyEntry
+		if err := json.Unmarshal(it.ValueBytes(), &e); err != nil {
+			it.Close()
+			return nil, errors.WithMessagef(err, "decoding payment %s", it.Key())
+		}
+		entries = append(entries, e)
+	}
+	return entries, errors.WithMessage(it.Close(), "closing iterator")
+}
+
+// persist writes `e` to the database. The caller is expected to hold the
+// mutex.
+func (h *historyStore) persist(id channel.ID, e historyEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return errors.WithMessage(err, "encoding payment")
+	}
+	// Inverted versions are zero-padded so that the entries of a channel are
+	// sorted newest first.
+	key := fmt.Sprintf("%s:%020d", hex.EncodeToString(id[:]), math.MaxUint64-e.Version)
+	return errors.WithMessage(h.db.PutBytes(key, data), "writing payment")
+}
+
+func (e historyEntry) direction() int {
+	for _, d := range e.Deltas {
+		switch d.Sign() {
+		case 1:
+			return DirectionIncoming
+		case -1:
+			return DirectionOutgoing
+		}
+	}
+	return DirectionNone
+}
+
+// GetHistory returns at most `limit` entries of the channel's payment history,
+// newest first, skipping the first `offset` ones. Every payment since the
+// channel was opened is contained, including restored channels if
+// persistence was enabled before the updates. The history of the channels that
+// this channel replaced follows its own history, see Rollover.
+func (c *PaymentChannel) GetHistory(offset, limit int) (*Payments, error) {
+	if offset < 0 || limit < 0 {
+		return nil, errors.New("offset and limit must not be negative")
+	}
+	ids, err := c.c.rollovers.chain(c.ch.ID())
+	if err != nil {
+		return nil, err
+	}
+	// Only the first offset+limit entries of the chain are read.
+	need := offset + limit
+	if need < offset {
+		need = maxInt
+	}
+	var entries []chanHistoryEntry
+	for _, id := range ids {
+		if len(entries) == need {
+			break
+		}
+		es, err := c.c.history.list(id, need-len(entries))
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range es {
+			entries = append(entries, chanHistoryEntry{id, e})
+		}
+	}
+	if offset > len(entries) {
+		offset = len(entries)
+	}
+	if limit > len(entries)-offset {
+		limit = len(entries) - offset
+	}
+
+	payments := make([]Payment, limit)
+	for i, e := range entries[offset : offset+limit] {
+		memo, err := c.c.memos.get(e.id, e.Version)
+		if err != nil {
+			return nil, err
+		}
+		payments[i] = Payment{
+			ChannelID: append([]byte(nil), e.id[:]...),
+			Version:   int64(e.Version),
+			Timestamp: e.Timestamp,
+			Deltas:    &BigInts{e.Deltas},
+			Direction: e.direction(),
+			Memo:      memo,
+		}
+	}
+	return &Payments{payments}, nil
+}
+
+// Length returns the length of the Payments slice.
+func (ps *Payments) Length() int {
+	return len(ps.values)
+}
+
+// Get returns the element at the given index.
+func (ps *Payments) Get(index int) (*Payment, error) {
+	if index < 0 || index >= len(ps.values) {
+		return nil, errors.New("get: index out of range")
+	}
+	p := ps.values[index]
+	return &p, nil
+}
@@ -0,0 +1,175 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/pkg/sortedkv/memorydb"
+	pkgtest "perun.network/go-perun/pkg/test"
+)
+
+// recordVersions records updates of channel `id` to all `versions` in the
+// given order. The balance of index 1 changes by the version each time.
+func recordVersions(h *historyStore, id channel.ID, versions ...uint64) {
+	for _, v := range versions {
+		h.record(id, 1, balState(v-1, 10, 10), balState(v, 10-int64(v), 10+int64(v)))
+	}
+}
+
+func versions(entries []historyEntry) []uint64 {
+	vs := make([]uint64, len(entries))
+	for i, e := range entries {
+		vs[i] = e.Version
+	}
+	return vs
+}
+
+// withLocked adds a sub-allocation of sub-channel `sub` to `s`.
+func withLocked(s *channel.State, sub channel.ID) *channel.State {
+	s.Locked = append(s.Locked, *channel.NewSubAlloc(sub, []channel.Bal{big.NewInt(1)}))
+	return s
+}
+
+func TestHistoryStore_Record(t *testing.T) {
+	final := balState(1, 10, 10)
+	final.IsFinal = true
+	tests := []struct {
+		name      string
+		from, to  *channel.State
+		delta     int64
+		direction int
+		stored    bool
+	}{
+		{"incoming", balState(0, 10, 10), balState(1, 7, 13), 3, DirectionIncoming, true},
+		{"outgoing", balState(0, 10, 10), balState(1, 12, 8), -2, DirectionOutgoing, true},
+		{"finalization", balState(0, 10, 10), final, 0, DirectionNone, true},
+		{"app data only", balState(0, 10, 10), balState(1, 10, 10), 0, DirectionNone, false},
+		{"sub-channel funding", balState(0, 10, 10), withLocked(balState(1, 10, 9), channel.ID{2}), -1, DirectionOutgoing, false},
+		{"sub-channel withdrawal", withLocked(balState(0, 10, 9), channel.ID{2}), balState(1, 10, 10), 1, DirectionIncoming, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHistoryStore()
+			e := h.record(channel.ID{1}, 1, tt.from, tt.to)
+			assert.Equal(t, tt.to.Version, e.Version)
+			require.Len(t, e.Deltas, 1)
+			assert.Equal(t, tt.delta, e.Deltas[0].Int64())
+			assert.Equal(t, tt.direction, e.direction())
+
+			entries, err := h.list(channel.ID{1}, maxInt)
+			require.NoError(t, err)
+			assert.Equal(t, tt.stored, len(entries) == 1)
+		})
+	}
+}
+
+func TestHistoryStore_List(t *testing.T) {
+	id, other := channel.ID{1}, channel.ID{2}
+	for _, tt := range []struct {
+		name    string
+		persist bool
+	}{{"memory", false}, {"database", true}} {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHistoryStore()
+			if tt.persist {
+				require.NoError(t, h.enablePersistence(memorydb.NewDatabase()))
+			}
+			// Versions above 9 check that the keys are sorted numerically.
+			recordVersions(h, id, 1, 2, 9, 10, 11)
+			recordVersions(h, other, 3)
+
+			entries, err := h.list(id, maxInt)
+			require.NoError(t, err)
+			assert.Equal(t, []uint64{11, 10, 9, 2, 1}, versions(entries))
+			assert.Equal(t, int64(11), entries[0].Deltas[0].Int64())
+
+			entries, err = h.list(id, 2)
+			require.NoError(t, err)
+			assert.Equal(t, []uint64{11, 10}, versions(entries))
+
+			entries, err = h.list(channel.ID{3}, maxInt)
+			require.NoError(t, err)
+			assert.Empty(t, entries)
+		})
+	}
+}
+
+func TestHistoryStore_Persistence(t *testing.T) {
+	id := channel.ID{1}
+	h := newHistoryStore()
+	recordVersions(h, id, 1, 2)
+
+	db := memorydb.NewDatabase()
+	require.NoError(t, h.enablePersistence(db))
+	assert.Empty(t, h.entries, "entries must be moved into the database")
+	recordVersions(h, id, 3)
+
+	// A new store on the same database sees all entries.
+	restored := newHistoryStore()
+	require.NoError(t, restored.enablePersistence(db))
+	entries, err := restored.list(id, maxInt)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{3, 2, 1}, versions(entries))
+}
+
+func TestPaymentChannel_GetHistory(t *testing.T) {
+	rng := pkgtest.Prng(t)
+	tc := newTestChannel(t, rng, &Config{})
+	c := &PaymentChannel{tc.alice, tc.bob}
+	id, pred := tc.alice.ID(), channel.ID{1}
+	recordVersions(tc.bob.history, id, 1, 2, 3)
+	// The history of the predecessor follows the channel's own history.
+	require.NoError(t, tc.bob.rollovers.link(id, pred))
+	recordVersions(tc.bob.history, pred, 1, 2)
+	require.NoError(t, tc.bob.memos.put(id, 2, "coffee"))
+
+	type payment struct {
+		id      channel.ID
+		version uint64
+	}
+	all := []payment{{id, 3}, {id, 2}, {id, 1}, {pred, 2}, {pred, 1}}
+	tests := []struct {
+		name          string
+		offset, limit int
+		want          []payment
+	}{
+		{"all", 0, 10, all},
+		{"first page", 0, 2, all[:2]},
+		{"second page", 2, 2, all[2:4]},
+		{"last page", 4, 2, all[4:]},
+		{"past the end", 6, 2, nil},
+		{"zero limit", 0, 0, nil},
+		{"largest limit", 1, maxInt, all[1:]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps, err := c.GetHistory(tt.offset, tt.limit)
+			require.NoError(t, err)
+			require.Equal(t, len(tt.want), ps.Length())
+			for i, want := range tt.want {
+				p, err := ps.Get(i)
+				require.NoError(t, err)
+				assert.Equal(t, want.id[:], p.ChannelID)
+				assert.Equal(t, int64(want.version), p.Version)
+				assert.Equal(t, DirectionIncoming, p.Direction)
+				if want.id == id && want.version == 2 {
+					assert.Equal(t, "coffee", p.Memo)
+				} else {
+					assert.Empty(t, p.Memo)
+				}
+			}
+		})
+	}
+
+	_, err := c.GetHistory(-1, 1)
+	assert.Error(t, err)
+}
@@ -0,0 +1,217 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/log"
+	perunio "perun.network/go-perun/pkg/io"
+	"perun.network/go-perun/pkg/sortedkv"
+	"perun.network/go-perun/wire"
+)
+
+// memoPrefix is the prefix of the memo table in the database.
+const memoPrefix = "prnm:Memo:"
+
+// maxPendingMemos is the number of upcoming versions of a channel for which
+// memos are accepted before their updates arrive.
+const maxPendingMemos = 16
+
+func init() {
+	wire.RegisterExternalDecoder(msgMemo, func(r io.Reader) (wire.Msg, error) {
+		var m memoMsg
+		return &m, m.Decode(r)
+	}, "Memo")
+}
+
+type (
+	// memoMsg attaches a memo to the update of channel `ChannelID` to
+	// `Version`. It is sent right before the update.
+	memoMsg struct {
+		ChannelID channel.ID
+		Version   uint64
+		Memo      string
+	}
+
+	memoKey struct {
+		id      channel.ID
+		version uint64
+	}
+
+	// pendingMemo is a received memo whose update was not accepted yet.
+	pendingMemo struct {
+		sender wire.Address
+		memo   string
+	}
+
+	// memoStore holds the memos of all accepted updates. They are kept in
+	// memory until persistence is enabled and stored in the database
+	// afterwards.
+	memoStore struct {
+		mu      sync.Mutex
+		pending map[memoKey]pendingMemo
+		memos   map[memoKey]string
+		db      sortedkv.Database // nil until persistence is enabled.
+	}
+)
+
+// Type returns msgMemo.
+func (memoMsg) Type() wire.Type {
+	return msgMemo
+}
+
+// Encode encodes the memoMsg into an io.Writer.
+func (m memoMsg) Encode(w io.Writer) error {
+	return perunio.Encode(w, m.ChannelID, m.Version, m.Memo)
+}
+
+// Decode decodes a memoMsg from an io.Reader.
+func (m *memoMsg) Decode(r io.Reader) error {
+	return perunio.Decode(r, &m.ChannelID, &m.Version, &m.Memo)
+}
+
+func newMemoStore() *memoStore {
+	return &memoStore{pending: make(map[memoKey]pendingMemo), memos: make(map[memoKey]string)}
+}
+
+// enablePersistence moves all memos into `db`.
+func (s *memoStore) enablePersistence(db sortedkv.Database) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.db = sortedkv.NewTable(db, memoPrefix)
+	for k, memo := range s.memos {
+		if err := s.db.Put(k.dbKey(), memo); err != nil {
+			return errors.WithMessage(err, "writing memo")
+		}
+		delete(s.memos, k)
+	}
+	return nil
+}
+
+// handleMemo stores the memo `m` of `sender` until its update arrives. Memos
+// for unknown channels, from other parties than the channel's peers or for
+// versions that are not among the next maxPendingMemos are dropped, so that
+// the pending memos can not grow without bound.
+func (c *Client) handleMemo(sender wire.Address, m *memoMsg) {
+	ch, ok := c.chans.get(m.ChannelID)
+	if !ok {
+		log.WithField("channel", m.ChannelID).Debug("Dropped memo for unknown channel")
+		return
+	}
+	state, ok := c.chans.state(m.ChannelID)
+	if !ok || !isPeer(ch, sender) {
+		log.WithField("channel", m.ChannelID).Warn("Dropped memo from non-peer")
+		return
+	}
+	if m.Version <= state.Version || m.Version > state.Version+maxPendingMemos {
+		log.WithField("channel", m.ChannelID).Warnf("Dropped memo for version %d", m.Version)
+		return
+	}
+	c.memos.addPending(sender, m)
+}
+
+// addPending stores a received memo until its update is handled.
+func (s *memoStore) addPending(sender wire.Address, m *memoMsg) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending[memoKey{m.ChannelID, m.Version}] = pendingMemo{sender: sender, memo: m.Memo}
+}
+
+// takePending returns the memo that `peer` sent for the update of channel `id`
+// to `version`. Older pending memos of the channel are dropped.
+func (s *memoStore) takePending(id channel.ID, version uint64, peer wire.Address) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var memo string
+	for k, p := range s.pending {
+		if k.id != id || k.version > version {
+			continue
+		}
+		if k.version == version && p.sender.Equals(peer) {
+			memo = p.memo
+		}
+		delete(s.pending, k)
+	}
+	return memo
+}
+
+// dropPending drops all pending memos of channel `id`.
+func (s *memoStore) dropPending(id channel.ID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k := range s.pending {
+		if k.id == id {
+			delete(s.pending, k)
+		}
+	}
+}
+
+// put stores the memo of the accepted update of channel `id` to `version`.
+func (s *memoStore) put(id channel.ID, version uint64, memo string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := memoKey{id, version}
+	if s.db == nil {
+		s.memos[k] = memo
+		return nil
+	}
+	return errors.WithMessage(s.db.Put(k.dbKey(), memo), "writing memo")
+}
+
+// get returns the memo of the update of channel `id` to `version` or an empty
+// string if it has none.
+func (s *memoStore) get(id channel.ID, version uint64) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := memoKey{id, version}
+	if s.db == nil {
+		return s.memos[k], nil
+	}
+	if has, err := s.db.Has(k.dbKey()); err != nil || !has {
+		return "", errors.WithMessage(err, "reading memo")
+	}
+	memo, err := s.db.Get(k.dbKey())
+	return memo, errors.WithMessage(err, "reading memo")
+}
+
+// dbKey returns the database key of the memo. Versions are zero-padded so that
+// the memos of a channel are sorted by version.
+func (k memoKey) dbKey() string {
+	return fmt.Sprintf("%s:%020d", hex.EncodeToString(k.id[:]), k.version)
+}
+
+// sendMemo sends `memo` for the update of the channel to `version` to all
+// peers.
+func (c *PaymentChannel) sendMemo(ctx *Context, version uint64, memo string) error {
+	m := &memoMsg{ChannelID: c.ch.ID(), Version: version, Memo: memo}
+	for i, peer := range c.ch.Peers() {
+		if i == int(c.ch.Idx()) {
+			continue
+		}
+		err := c.c.bus.Publish(ctx.ctx, &wire.Envelope{Sender: c.c.bus.self, Recipient: peer, Msg: m})
+		if err != nil {
+			return errors.WithMessage(err, "sending memo")
+		}
+	}
+	return nil
+}
+
+// GetMemo returns the memo of the payment that resulted in the state with the
+// given version, or an empty string if it has none.
+func (c *PaymentChannel) GetMemo(version int64) (string, error) {
+	return c.c.memos.get(c.ch.ID(), uint64(version))
+}
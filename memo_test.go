@@ -0,0 +1,155 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	ethwallet "perun.network/go-perun/backend/ethereum/wallet"
+	ethwallettest "perun.network/go-perun/backend/ethereum/wallet/test"
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/pkg/sortedkv/memorydb"
+	pkgtest "perun.network/go-perun/pkg/test"
+	"perun.network/go-perun/wallet"
+)
+
+func newRandomAddress(rng *rand.Rand) *ethwallet.Address {
+	addr := ethwallettest.NewRandomAddress(rng)
+	return &addr
+}
+
+func TestMemoStore_TakePending(t *testing.T) {
+	rng := pkgtest.Prng(t)
+	alice, bob := newRandomAddress(rng), newRandomAddress(rng)
+	id, other := channel.ID{1}, channel.ID{2}
+
+	tests := []struct {
+		name    string
+		pending []*memoMsg
+		sender  wallet.Address // Sender of the pending memos.
+		version uint64
+		peer    wallet.Address
+		memo    string
+		left    int // Pending memos that are left afterwards.
+	}{
+		{
+			name:    "matching memo",
+			pending: []*memoMsg{{ChannelID: id, Version: 3, Memo: "coffee"}},
+			sender:  alice, version: 3, peer: alice, memo: "coffee",
+		},
+		{
+			name:    "memo of other peer",
+			pending: []*memoMsg{{ChannelID: id, Version: 3, Memo: "coffee"}},
+			sender:  bob, version: 3, peer: alice,
+		},
+		{
+			name:    "future memo is kept",
+			pending: []*memoMsg{{ChannelID: id, Version: 4, Memo: "tea"}},
+			sender:  alice, version: 3, peer: alice, left: 1,
+		},
+		{
+			name: "older memos are dropped",
+			pending: []*memoMsg{
+				{ChannelID: id, Version: 1, Memo: "old"},
+				{ChannelID: id, Version: 2, Memo: "older"},
+				{ChannelID: id, Version: 3, Memo: "coffee"},
+			},
+			sender: alice, version: 3, peer: alice, memo: "coffee",
+		},
+		{
+			name: "memos of other channels are kept",
+			pending: []*memoMsg{
+				{ChannelID: other, Version: 3, Memo: "tea"},
+				{ChannelID: id, Version: 3, Memo: "coffee"},
+			},
+			sender: alice, version: 3, peer: alice, memo: "coffee", left: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newMemoStore()
+			for _, m := range tt.pending {
+				s.addPending(tt.sender, m)
+			}
+			assert.Equal(t, tt.memo, s.takePending(id, tt.version, tt.peer))
+			assert.Len(t, s.pending, tt.left)
+		})
+	}
+}
+
+func TestMemoStore_DropPending(t *testing.T) {
+	rng := pkgtest.Prng(t)
+	alice := newRandomAddress(rng)
+	s := newMemoStore()
+	s.addPending(alice, &memoMsg{ChannelID: channel.ID{1}, Version: 1, Memo: "a"})
+	s.addPending(alice, &memoMsg{ChannelID: channel.ID{1}, Version: 2, Memo: "b"})
+	s.addPending(alice, &memoMsg{ChannelID: channel.ID{2}, Version: 1, Memo: "c"})
+
+	s.dropPending(channel.ID{1})
+	assert.Len(t, s.pending, 1)
+	assert.Equal(t, "c", s.takePending(channel.ID{2}, 1, alice))
+}
+
+func TestMemoStore_Persistence(t *testing.T) {
+	id := channel.ID{1}
+	s := newMemoStore()
+	require.NoError(t, s.put(id, 1, "before"))
+
+	db := memorydb.NewDatabase()
+	require.NoError(t, s.enablePersistence(db))
+	assert.Empty(t, s.memos, "memos must be moved into the database")
+	require.NoError(t, s.put(id, 2, "after"))
+
+	// A new store on the same database sees all memos.
+	restored := newMemoStore()
+	require.NoError(t, restored.enablePersistence(db))
+	for _, tt := range []struct {
+		version uint64
+		memo    string
+	}{{1, "before"}, {2, "after"}, {3, ""}} {
+		memo, err := restored.get(id, tt.version)
+		require.NoError(t, err)
+		assert.Equal(t, tt.memo, memo, "version %d", tt.version)
+	}
+	memo, err := restored.get(channel.ID{2}, 1)
+	require.NoError(t, err)
+	assert.Empty(t, memo)
+}
+
+func TestClient_HandleMemo(t *testing.T) {
+	rng := pkgtest.Prng(t)
+	tc := newTestChannel(t, rng, &Config{})
+	id, alice := tc.alice.ID(), tc.alice.Peers()[0]
+
+	tests := []struct {
+		name    string
+		sender  wallet.Address
+		id      channel.ID
+		version uint64
+		kept    bool
+	}{
+		{"next update", alice, id, 1, true},
+		{"last pending update", alice, id, maxPendingMemos, true},
+		{"too far ahead", alice, id, maxPendingMemos + 1, false},
+		{"current version", alice, id, 0, false},
+		{"unknown channel", alice, channel.ID{1}, 1, false},
+		{"non-peer", newRandomAddress(rng), id, 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc.bob.handleMemo(tt.sender, &memoMsg{ChannelID: tt.id, Version: tt.version, Memo: "coffee"})
+			memo := ""
+			if tt.kept {
+				memo = "coffee"
+			}
+			assert.Equal(t, memo, tc.bob.memos.takePending(tt.id, tt.version, tt.sender))
+		})
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"io"
+	"math/big"
+
+	"github.com/pkg/errors"
+
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/log"
+	perunio "perun.network/go-perun/pkg/io"
+	"perun.network/go-perun/wire"
+)
+
+func init() {
+	wire.RegisterExternalDecoder(msgPaymentRequest, func(r io.Reader) (wire.Msg, error) {
+		var m paymentRequestMsg
+		return &m, m.Decode(r)
+	}, "PaymentRequest")
+}
+
+type (
+	// paymentRequestMsg asks the recipient to pay `Amount` of the asset with
+	// index `AssetIdx` to the sender in channel `ChannelID`.
+	paymentRequestMsg struct {
+		ChannelID channel.ID
+		AssetIdx  uint16
+		Amount    *big.Int
+		Memo      string
+	}
+
+	// PaymentRequest is a request of the peer to be paid in a channel. It is
+	// passed to UpdateHandler.HandlePaymentRequest. The payment is only made
+	// if the user calls Approve.
+	PaymentRequest struct {
+		ChannelID []byte
+		AssetIdx  int     // Index of the requested asset.
+		Amount    *BigInt // Requested amount.
+		Memo      string  // Optional description of the payment.
+
+		ch   *PaymentChannel
+		from int // Channel index of the requester.
+	}
+)
+
+// Type returns msgPaymentRequest.
+func (paymentRequestMsg) Type() wire.Type {
+	return msgPaymentRequest
+}
+
+// Encode encodes the paymentRequestMsg into an io.Writer.
+func (m paymentRequestMsg) Encode(w io.Writer) error {
+	return perunio.Encode(w, m.ChannelID, m.AssetIdx, m.Amount, m.Memo)
+}
+
+// Decode decodes a paymentRequestMsg from an io.Reader.
+func (m *paymentRequestMsg) Decode(r io.Reader) error {
+	return perunio.Decode(r, &m.ChannelID, &m.AssetIdx, &m.Amount, &m.Memo)
+}
+
+// RequestPayment asks the peer to pay `amount` of the first asset to us. Only
+// positive amounts are supported. Returns once the request was sent, the
+// payment arrives as regular update if the peer approves it.
+func (c *PaymentChannel) RequestPayment(ctx *Context, amount *BigInt, memo string) error {
+	return c.RequestAssetPayment(ctx, 0, amount, memo)
+}
+
+// RequestAssetPayment asks the peer to pay `amount` of the asset at index
+// `assetIdx` to us. It otherwise behaves like RequestPayment.
+func (c *PaymentChannel) RequestAssetPayment(ctx *Context, assetIdx int, amount *BigInt, memo string) error {
+	if amount.i.Sign() < 1 {
+		return errors.New("Only positive amounts supported in payment requests")
+	}
+	if assetIdx < 0 || assetIdx >= len(c.ch.State().Assets) {
+		return errors.New("asset index out of range")
+	}
+	peers := c.ch.Peers()
+	if len(peers) != 2 {
+		return errors.New("payment requests are only supported in two-party channels")
+	}
+
+	m := &paymentRequestMsg{
+		ChannelID: c.ch.ID(),
+		AssetIdx:  uint16(assetIdx),
+		Amount:    new(big.Int).Set(amount.i),
+		Memo:      memo,
+	}
+	peer := peers[1-c.ch.Idx()]
+	err := c.c.bus.Publish(ctx.ctx, &wire.Envelope{Sender: c.c.bus.self, Recipient: peer, Msg: m})
+	return errors.WithMessage(err, "sending payment request")
+}
+
+// Approve pays the requested amount to the requester with the memo of the
+// request.
+func (r *PaymentRequest) Approve(ctx *Context) error {
+	return r.ch.SendAsset(ctx, r.AssetIdx, r.from, r.Amount, r.Memo)
+}
+
+// GetChannel returns the channel that the payment is requested in.
+func (r *PaymentRequest) GetChannel() *PaymentChannel {
+	return r.ch
+}
+
+// handlePaymentRequest passes a payment request of `sender` to the
+// UpdateHandler if the sender is our peer in the requested channel.
+func (c *Client) handlePaymentRequest(sender wire.Address, m *paymentRequestMsg) {
+	ch, ok := c.chans.get(m.ChannelID)
+	if !ok {
+		log.WithField("channel", m.ChannelID).Warn("Ignored payment request for unknown channel")
+		return
+	}
+	peers := ch.Peers()
+	if len(peers) != 2 || !peers[1-ch.Idx()].Equals(sender) {
+		log.WithField("channel", m.ChannelID).Warn("Ignored payment request from non-participant")
+		return
+	}
+	if m.Amount.Sign() < 1 || int(m.AssetIdx) >= len(ch.State().Assets) {
+		log.WithField("channel", m.ChannelID).Warn("Ignored invalid payment request")
+		return
+	}
+	h := c.updateHandler()
+	if h == nil {
+		log.WithField("channel", m.ChannelID).Warn("Ignored payment request without UpdateHandler")
+		return
+	}
+
+	h.HandlePaymentRequest(&PaymentRequest{
+		ChannelID: m.ChannelID[:],
+		AssetIdx:  int(m.AssetIdx),
+		Amount:    &BigInt{m.Amount},
+		Memo:      m.Memo,
+		ch:        &PaymentChannel{ch, c},
+		from:      int(1 - ch.Idx()),
+	})
+}
@@ -0,0 +1,194 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"fmt"
+	"math/big"
+	"sync"
+
+	ethwallet "perun.network/go-perun/backend/ethereum/wallet"
+	"perun.network/go-perun/log"
+)
+
+const (
+	// defaultPolicyAcceptTimeout is the default time in seconds that a
+	// ProposalPolicy waits for an automatically accepted channel to be funded.
+	defaultPolicyAcceptTimeout = 600
+	// policyRejectTimeout is the time in seconds that sending a rejection may
+	// take.
+	policyRejectTimeout = 10
+)
+
+// ProposalPolicy is a ProposalHandler that checks incoming proposals against
+// configurable limits. Proposals that violate a limit are rejected with the
+// violated limit as reason. All other proposals are accepted if AutoAccept is
+// enabled and otherwise passed to the wrapped ProposalHandler.
+// Automatically accepted channels are reported to the NewChannelCallback of the
+// Client, see Client.OnNewChannel.
+// Unset limits are not checked. All setters are thread safe and can be called
+// while the policy is in use.
+//
+// Use it in place of a ProposalHandler with Client.Handle.
+type ProposalPolicy struct {
+	mu sync.RWMutex
+	h  ProposalHandler // wrapped ProposalHandler, can be nil.
+
+	peers       map[ethwallet.Address]bool // allow-list, empty allows all.
+	minCD       int64                      // 0 means no limit.
+	maxCD       int64                      // 0 means no limit.
+	maxDeposit  map[ethwallet.Address]*big.Int
+	maxCapacity map[ethwallet.Address]*big.Int
+	autoAccept  bool
+	timeout     int
+}
+
+// NewProposalPolicy creates a ProposalPolicy without limits that passes all
+// proposals to `handler`. `handler` can be nil, in which case proposals that
+// are not automatically accepted are rejected.
+func NewProposalPolicy(handler ProposalHandler) *ProposalPolicy {
+	return &ProposalPolicy{
+		h:           handler,
+		peers:       make(map[ethwallet.Address]bool),
+		maxDeposit:  make(map[ethwallet.Address]*big.Int),
+		maxCapacity: make(map[ethwallet.Address]*big.Int),
+		timeout:     defaultPolicyAcceptTimeout,
+	}
+}
+
+// AllowPeer adds `perunID` to the allowed peers. Once a peer was allowed, all
+// proposals from peers that are not allowed are rejected.
+func (p *ProposalPolicy) AllowPeer(perunID *Address) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.peers[perunID.addr] = true
+}
+
+// DisallowPeer removes `perunID` from the allowed peers.
+func (p *ProposalPolicy) DisallowPeer(perunID *Address) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.peers, perunID.addr)
+}
+
+// SetChallengeDurationRange sets the minimal and maximal accepted challenge
+// duration in seconds. 0 disables the respective limit.
+func (p *ProposalPolicy) SetChallengeDurationRange(min, max int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.minCD, p.maxCD = min, max
+}
+
+// SetMaxOwnDeposit sets the maximal amount of the asset `assetHolder` that we
+// fund in a proposed channel. nil disables the limit.
+func (p *ProposalPolicy) SetMaxOwnDeposit(assetHolder *Address, max *BigInt) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	setLimit(p.maxDeposit, assetHolder, max)
+}
+
+// SetMaxTotalCapacity sets the maximal amount of the asset `assetHolder` that
+// all participants together hold in a proposed channel. nil disables the
+// limit.
+func (p *ProposalPolicy) SetMaxTotalCapacity(assetHolder *Address, max *BigInt) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	setLimit(p.maxCapacity, assetHolder, max)
+}
+
+// SetAutoAccept sets whether proposals within all limits are accepted without
+// asking the wrapped ProposalHandler.
+func (p *ProposalPolicy) SetAutoAccept(autoAccept bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.autoAccept = autoAccept
+}
+
+// SetAcceptTimeout sets the time in seconds that automatically accepted
+// channels have to be funded in. Defaults to 600 seconds. It should be at least
+// twice the maximal challenge duration, see ProposalResponder.Accept.
+func (p *ProposalPolicy) SetAcceptTimeout(seconds int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.timeout = seconds
+}
+
+// Check returns the reason why `prop` violates the policy or an empty string if
+// it is within all limits.
+func (p *ProposalPolicy) Check(prop *ChannelProposal) string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if len(p.peers) != 0 && !p.peers[prop.Peer.addr] {
+		return "peer not allowed"
+	}
+	if p.minCD != 0 && prop.ChallengeDuration < p.minCD {
+		return fmt.Sprintf("challenge duration below %d seconds", p.minCD)
+	}
+	if p.maxCD != 0 && prop.ChallengeDuration > p.maxCD {
+		return fmt.Sprintf("challenge duration above %d seconds", p.maxCD)
+	}
+	for i, asset := range prop.FundingAgreement.assets {
+		addr := *asset.(*ethwallet.Address)
+		// The proposee has index 1.
+		if max, ok := p.maxDeposit[addr]; ok && prop.FundingAgreement.balances[i][1].Cmp(max) > 0 {
+			return fmt.Sprintf("own deposit of asset %v above %v", &addr, max)
+		}
+		if max, ok := p.maxCapacity[addr]; ok && sum(prop.InitAlloc.balances[i]).Cmp(max) > 0 {
+			return fmt.Sprintf("capacity of asset %v above %v", &addr, max)
+		}
+	}
+	return ""
+}
+
+// HandleProposal implements the ProposalHandler interface.
+func (p *ProposalPolicy) HandleProposal(prop *ChannelProposal, resp *ProposalResponder) {
+	if reason := p.Check(prop); reason != "" {
+		p.reject(prop, resp, reason)
+		return
+	}
+
+	p.mu.RLock()
+	h, autoAccept, timeout := p.h, p.autoAccept, p.timeout
+	p.mu.RUnlock()
+	switch {
+	case autoAccept:
+		ctx := ContextWithTimeout(timeout)
+		defer ctx.Cancel()
+		if _, err := resp.Accept(ctx); err != nil {
+			log.WithError(err).Warn("Accepting proposal failed")
+		}
+	case h != nil:
+		h.HandleProposal(prop, resp)
+	default:
+		p.reject(prop, resp, "no proposal handler")
+	}
+}
+
+func (p *ProposalPolicy) reject(prop *ChannelProposal, resp *ProposalResponder, reason string) {
+	log.WithField("peer", prop.Peer.ToHex()).Info("Rejecting proposal: ", reason)
+	ctx := ContextWithTimeout(policyRejectTimeout)
+	defer ctx.Cancel()
+	if err := resp.Reject(ctx, reason); err != nil {
+		log.WithError(err).Warn("Rejecting proposal failed")
+	}
+}
+
+func setLimit(limits map[ethwallet.Address]*big.Int, assetHolder *Address, max *BigInt) {
+	if max == nil {
+		delete(limits, assetHolder.addr)
+		return
+	}
+	limits[assetHolder.addr] = new(big.Int).Set(max.i)
+}
+
+func sum(bals []*big.Int) *big.Int {
+	s := new(big.Int)
+	for _, b := range bals {
+		s.Add(s, b)
+	}
+	return s
+}
@@ -0,0 +1,337 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	ethwallet "perun.network/go-perun/backend/ethereum/wallet"
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/client"
+	"perun.network/go-perun/log"
+	perunio "perun.network/go-perun/pkg/io"
+	"perun.network/go-perun/pkg/sortedkv"
+	"perun.network/go-perun/wire"
+)
+
+const (
+	// rolloverPrefix is the prefix of the table in the database that maps
+	// channels to the channels that they replaced.
+	rolloverPrefix = "prnm:Rollover:"
+	// rolloverPollInterval is the time between two checks whether the
+	// proposer of a rollover finalized the replaced channel.
+	rolloverPollInterval = 100 * time.Millisecond
+)
+
+func init() {
+	wire.RegisterExternalDecoder(msgRollover, func(r io.Reader) (wire.Msg, error) {
+		var m rolloverMsg
+		return &m, m.Decode(r)
+	}, "Rollover")
+}
+
+type (
+	// rolloverMsg announces that the proposal `ProposalID` replaces the
+	// channel `Predecessor`. It is sent right before the proposal.
+	rolloverMsg struct {
+		ProposalID  client.ProposalID
+		Predecessor channel.ID
+	}
+
+	// pendingRollover is a received rolloverMsg whose proposal was not
+	// handled yet.
+	pendingRollover struct {
+		sender      wire.Address
+		predecessor channel.ID
+	}
+
+	// rolloverStore links channels to their predecessors. Links are kept in
+	// memory until persistence is enabled and stored in the database
+	// afterwards.
+	rolloverStore struct {
+		mu      sync.Mutex
+		pending map[client.ProposalID]pendingRollover
+		links   map[channel.ID]channel.ID
+		db      sortedkv.Database // nil until persistence is enabled.
+	}
+)
+
+// Type returns msgRollover.
+func (rolloverMsg) Type() wire.Type {
+	return msgRollover
+}
+
+// Encode encodes the rolloverMsg into an io.Writer.
+func (m rolloverMsg) Encode(w io.Writer) error {
+	return perunio.Encode(w, m.ProposalID, m.Predecessor)
+}
+
+// Decode decodes a rolloverMsg from an io.Reader.
+func (m *rolloverMsg) Decode(r io.Reader) error {
+	return perunio.Decode(r, &m.ProposalID, &m.Predecessor)
+}
+
+func newRolloverStore() *rolloverStore {
+	return &rolloverStore{
+		pending: make(map[client.ProposalID]pendingRollover),
+		links:   make(map[channel.ID]channel.ID),
+	}
+}
+
+// enablePersistence moves all links into `db`.
+func (s *rolloverStore) enablePersistence(db sortedkv.Database) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.db = sortedkv.NewTable(db, rolloverPrefix)
+	for id, pred := range s.links {
+		if err := s.db.PutBytes(hex.EncodeToString(id[:]), pred[:]); err != nil {
+			return errors.WithMessage(err, "writing rollover")
+		}
+		delete(s.links, id)
+	}
+	return nil
+}
+
+// handleRollover stores the rollover announcement `m` of `sender` until its
+// proposal arrives. Announcements for unknown channels or from other parties
+// than the channel's peers are dropped, so that there is at most one pending
+// rollover per open channel.
+func (c *Client) handleRollover(sender wire.Address, m *rolloverMsg) {
+	ch, ok := c.chans.get(m.Predecessor)
+	if !ok {
+		log.WithField("channel", m.Predecessor).Debug("Dropped rollover of unknown channel")
+		return
+	}
+	if !isPeer(ch, sender) {
+		log.WithField("channel", m.Predecessor).Warn("Dropped rollover from non-peer")
+		return
+	}
+	c.rollovers.addPending(sender, m)
+}
+
+// addPending stores a received rolloverMsg until its proposal is handled. It
+// replaces an older pending rollover of the same predecessor.
+func (s *rolloverStore) addPending(sender wire.Address, m *rolloverMsg) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deletePending(m.Predecessor)
+	s.pending[m.ProposalID] = pendingRollover{sender: sender, predecessor: m.Predecessor}
+}
+
+// dropPending drops the pending rollover of channel `pred`.
+func (s *rolloverStore) dropPending(pred channel.ID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deletePending(pred)
+}
+
+// deletePending deletes the pending rollovers of channel `pred`. The caller is
+// expected to hold the mutex.
+func (s *rolloverStore) deletePending(pred channel.ID) {
+	for propID, p := range s.pending {
+		if p.predecessor == pred {
+			delete(s.pending, propID)
+		}
+	}
+}
+
+// takePending returns the predecessor that `sender` announced for the
+// proposal `propID`.
+func (s *rolloverStore) takePending(propID client.ProposalID, sender wire.Address) (channel.ID, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.pending[propID]
+	delete(s.pending, propID)
+	if !ok || !p.sender.Equals(sender) {
+		return channel.ID{}, false
+	}
+	return p.predecessor, true
+}
+
+// link stores that channel `id` replaced channel `pred`.
+func (s *rolloverStore) link(id, pred channel.ID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.db == nil {
+		s.links[id] = pred
+		return nil
+	}
+	return errors.WithMessage(s.db.PutBytes(hex.EncodeToString(id[:]), pred[:]), "writing rollover")
+}
+
+// predecessor returns the channel that channel `id` replaced.
+func (s *rolloverStore) predecessor(id channel.ID) (pred channel.ID, ok bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.db == nil {
+		pred, ok = s.links[id]
+		return pred, ok, nil
+	}
+	key := hex.EncodeToString(id[:])
+	if ok, err = s.db.Has(key); err != nil || !ok {
+		return pred, false, errors.WithMessage(err, "reading rollover")
+	}
+	data, err := s.db.GetBytes(key)
+	if err != nil {
+		return pred, false, errors.WithMessage(err, "reading rollover")
+	}
+	copy(pred[:], data)
+	return pred, true, nil
+}
+
+// chain returns `id` followed by all its predecessors, newest first.
+func (s *rolloverStore) chain(id channel.ID) ([]channel.ID, error) {
+	ids := []channel.ID{id}
+	seen := map[channel.ID]bool{id: true}
+	for {
+		pred, ok, err := s.predecessor(ids[len(ids)-1])
+		if err != nil {
+			return nil, err
+		}
+		if !ok || seen[pred] {
+			return ids, nil
+		}
+		seen[pred] = true
+		ids = append(ids, pred)
+	}
+}
+
+// RolloverError is returned by Rollover if the channel was settled but the new
+// channel could not be opened.
+type RolloverError struct {
+	Settled *PaymentChannel // The settled and closed channel.
+	cause   error
+}
+
+// Error returns the error message.
+func (e *RolloverError) Error() string {
+	return fmt.Sprintf("opening new channel after settling: %v", e.cause)
+}
+
+// Unwrap returns the error of opening the new channel.
+func (e *RolloverError) Unwrap() error {
+	return e.cause
+}
+
+// Rollover replaces the channel with a new one that has the balances
+// `newBals` of the first asset. The balances are indexed like in this channel
+// and each must be at least the current balance of the participant, the
+// difference is the participant's top-up.
+// The new channel is proposed to the peer with the same challenge duration.
+// The peer sees the predecessor in ChannelProposal.RolloverOf. Only after the
+// peer accepted, this channel is finalized, settled and closed by both sides,
+// and then the new channel is funded. The funds of this channel are withdrawn
+// to the on-chain accounts, not to the WithdrawalReceiver, since the deposits
+// into the new channel are made from there. If the proposal fails, this
+// channel stays open. If opening the new channel fails after this channel was
+// settled, a RolloverError is returned, also by ProposalResponder.Accept.
+// Returns the new channel, which is linked to this one in the database, see
+// GetPredecessor and GetHistory.
+// Only supported for two-party payment channels with a single asset that are
+// not sub-channels.
+func (c *PaymentChannel) Rollover(ctx *Context, newBals *BigInts) (*PaymentChannel, error) {
+	params, state := c.ch.Params(), c.ch.State()
+	switch {
+	case c.ch.IsSubChannel():
+		return nil, errors.New("sub-channels can not be rolled over")
+	case !channel.IsNoApp(params.App):
+		return nil, errors.New("app channels can not be rolled over")
+	case len(state.Assets) != 1:
+		return nil, errors.New("only single-asset channels can be rolled over")
+	case len(params.Parts) != 2 || len(newBals.values) != 2:
+		return nil, errors.New("only two-party channels can be rolled over")
+	}
+	for i, bal := range state.Balances[0] {
+		if newBals.values[i].Cmp(bal) < 0 {
+			return nil, errors.Errorf("new balance of participant %d below current balance", i)
+		}
+	}
+
+	// We propose the new channel, so we get index 0 in it.
+	my := c.ch.Idx()
+	bals := []*big.Int{new(big.Int).Set(newBals.values[my]), new(big.Int).Set(newBals.values[1-my])}
+	alloc := &Allocation{assets: state.Assets, balances: [][]*big.Int{bals}}
+	peer := &Address{*c.ch.Peers()[1-my].(*ethwallet.Address)}
+	prop, err := c.c.newLedgerProposal(peer, int64(params.ChallengeDuration), alloc, channel.NoApp(), channel.NoData())
+	if err != nil {
+		return nil, err
+	}
+
+	m := &rolloverMsg{ProposalID: prop.ProposalID(), Predecessor: c.ch.ID()}
+	err = c.c.bus.Publish(ctx.ctx, &wire.Envelope{Sender: c.c.bus.self, Recipient: &peer.addr, Msg: m})
+	if err != nil {
+		return nil, errors.WithMessage(err, "sending rollover")
+	}
+	// The funds of this channel are deposited into the new one, so it is
+	// settled after the peer accepted and before the new channel is funded.
+	settled := false
+	cancel := c.c.assets.onFunding(*prop.Participant.(*ethwallet.Address), func() error {
+		if err := c.settleForRollover(ctx); err != nil {
+			return err
+		}
+		settled = true
+		return nil
+	})
+	defer cancel()
+
+	next, err := c.c.proposeChannel(ctx, prop)
+	if err != nil && settled {
+		return nil, &RolloverError{Settled: c, cause: err}
+	} else if err != nil {
+		return nil, err
+	}
+	return next, c.c.rollovers.link(next.ch.ID(), c.ch.ID())
+}
+
+// settleForRollover finalizes, settles and closes the channel. Our funds are
+// withdrawn to our on-chain account since the new channel is funded from it.
+func (c *PaymentChannel) settleForRollover(ctx *Context) error {
+	if !c.ch.State().IsFinal {
+		if err := c.Finalize(ctx); err != nil {
+			return errors.WithMessage(err, "finalizing")
+		}
+	}
+	receiver := &Address{*c.c.onChain.Address().(*ethwallet.Address)}
+	if err := c.SettleTo(ctx, false, receiver); err != nil {
+		return errors.WithMessage(err, "settling")
+	}
+	return errors.WithMessage(c.Close(), "closing")
+}
+
+// settleAcceptedRollover waits until the proposer of the rollover finalized
+// the channel and then settles and closes it like settleForRollover.
+func (c *PaymentChannel) settleAcceptedRollover(ctx *Context) error {
+	ticker := time.NewTicker(rolloverPollInterval)
+	defer ticker.Stop()
+	for !c.ch.State().IsFinal {
+		select {
+		case <-ctx.ctx.Done():
+			return errors.WithMessage(ctx.ctx.Err(), "waiting for finalization")
+		case <-c.ch.Ctx().Done():
+			return errors.New("channel closed before finalization")
+		case <-ticker.C:
+		}
+	}
+	return c.settleForRollover(ctx)
+}
+
+// GetPredecessor returns the ID of the channel that this channel replaced, see
+// Rollover, or nil if it did not replace a channel.
+func (c *PaymentChannel) GetPredecessor() ([]byte, error) {
+	pred, ok, err := c.c.rollovers.predecessor(c.ch.ID())
+	if err != nil || !ok {
+		return nil, err
+	}
+	return pred[:], nil
+}
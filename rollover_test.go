@@ -0,0 +1,289 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"context"
+	"math/big"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	ethchannel "perun.network/go-perun/backend/ethereum/channel"
+	ethchanneltest "perun.network/go-perun/backend/ethereum/channel/test"
+	ethwallet "perun.network/go-perun/backend/ethereum/wallet"
+	"perun.network/go-perun/backend/ethereum/wallet/keystore"
+	ethwallettest "perun.network/go-perun/backend/ethereum/wallet/test"
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/client"
+	pkgtest "perun.network/go-perun/pkg/test"
+	"perun.network/go-perun/wallet"
+	"perun.network/go-perun/wire/net"
+	nettest "perun.network/go-perun/wire/net/test"
+)
+
+type (
+	// simChain is a simulated blockchain with deployed contracts.
+	simChain struct {
+		*ethchanneltest.SimSetup
+		adjudicator, assetHolder common.Address
+	}
+
+	// acceptingResponder accepts all proposals with a ProposalResponder and
+	// passes the results on.
+	acceptingResponder struct {
+		ctx      *Context
+		accepted chan acceptResult
+	}
+
+	acceptResult struct {
+		prop *ChannelProposal
+		ch   *PaymentChannel
+		err  error
+	}
+)
+
+func newSimChain(t *testing.T, rng *rand.Rand) *simChain {
+	s := ethchanneltest.NewSimSetup(rng)
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	adj, err := ethchannel.DeployAdjudicator(ctx, *s.CB, s.TxSender.Account)
+	require.NoError(t, err)
+	asset, err := ethchannel.DeployETHAssetholder(ctx, *s.CB, adj, s.TxSender.Account)
+	require.NoError(t, err)
+	return &simChain{SimSetup: s, adjudicator: adj, assetHolder: asset}
+}
+
+// newSimClient returns a Client that settles and funds channels on `chain`
+// and is reachable over `hub`. It handles all proposals and updates with `ph`
+// and `uh`.
+func newSimClient(t *testing.T, rng *rand.Rand, chain *simChain, hub *nettest.ConnHub, cfg *Config, ph ProposalHandler, uh UpdateHandler) *Client {
+	w := ethwallettest.NewTmpWallet()
+	acc := w.NewRandomAccount(rng).(*keystore.Account)
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	chain.SimBackend.FundAddress(ctx, acc.Account.Address)
+	cb := ethchannel.NewContractBackend(chain.SimBackend, keystore.NewTransactor(*w, types.NewEIP155Signer(big.NewInt(1337))))
+
+	receiver := acc.Account.Address
+	if cfg.WithdrawalReceiver != nil {
+		receiver = common.Address(cfg.WithdrawalReceiver.addr)
+	}
+	adjudicator := newReceiverAdjudicator(cb, chain.adjudicator, receiver, acc.Account)
+	assets := newAssetRegistry(cb, acc.Account)
+	assets.register(ethwallet.Address(chain.assetHolder), new(ethchannel.ETHDepositor))
+	bus := newMsgBus(net.NewBus(acc, hub.NewNetDialer()), acc.Address(), cfg, newAddressBook(nil))
+	pc, err := client.New(acc.Address(), bus, assets, adjudicator, w)
+	require.NoError(t, err)
+
+	c := newTestClient(pc, cfg)
+	c.adjudicator, c.assets, c.bus, c.addrBook = adjudicator, assets, bus, bus.book
+	c.wallet, c.onChain = w, acc
+	bus.onRollover = c.handleRollover
+	go bus.Listen(hub.NewNetListener(acc.Address()))
+	go c.Handle(ph, uh)
+	t.Cleanup(func() {
+		pc.Close()
+		bus.Close()
+		c.events.close()
+	})
+	return c
+}
+
+func (h *acceptingResponder) HandleProposal(prop *ChannelProposal, r *ProposalResponder) {
+	ch, err := r.Accept(h.ctx)
+	h.accepted <- acceptResult{prop, ch, err}
+}
+
+// awaitAccepted returns the result of the next accepted proposal.
+func (h *acceptingResponder) awaitAccepted(t *testing.T) acceptResult {
+	select {
+	case res := <-h.accepted:
+		return res
+	case <-h.ctx.ctx.Done():
+		t.Fatal("proposal was not accepted")
+		return acceptResult{}
+	}
+}
+
+func TestRolloverStore_Pending(t *testing.T) {
+	rng := pkgtest.Prng(t)
+	alice, bob := newRandomAddress(rng), newRandomAddress(rng)
+	pred, other := channel.ID{1}, channel.ID{2}
+	propA, propB := client.ProposalID{1}, client.ProposalID{2}
+
+	tests := []struct {
+		name    string
+		pending []*rolloverMsg
+		propID  client.ProposalID
+		sender  wallet.Address
+		pred    *channel.ID // nil if the proposal is no rollover.
+		left    int         // Pending rollovers that are left afterwards.
+	}{
+		{
+			name:    "matching proposal",
+			pending: []*rolloverMsg{{ProposalID: propA, Predecessor: pred}},
+			propID:  propA, sender: alice, pred: &pred,
+		},
+		{
+			name:    "proposal of other sender",
+			pending: []*rolloverMsg{{ProposalID: propA, Predecessor: pred}},
+			propID:  propA, sender: bob,
+		},
+		{
+			name:    "other proposal",
+			pending: []*rolloverMsg{{ProposalID: propA, Predecessor: pred}},
+			propID:  propB, sender: alice, left: 1,
+		},
+		{
+			name: "newer rollover of same channel replaces older one",
+			pending: []*rolloverMsg{
+				{ProposalID: propA, Predecessor: pred},
+				{ProposalID: propB, Predecessor: pred},
+			},
+			propID: propA, sender: alice, left: 1,
+		},
+		{
+			name: "rollovers of other channels are kept",
+			pending: []*rolloverMsg{
+				{ProposalID: propA, Predecessor: pred},
+				{ProposalID: propB, Predecessor: other},
+			},
+			propID: propA, sender: alice, pred: &pred, left: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newRolloverStore()
+			for _, m := range tt.pending {
+				s.addPending(alice, m)
+			}
+			got, ok := s.takePending(tt.propID, tt.sender)
+			if assert.Equal(t, tt.pred != nil, ok) && ok {
+				assert.Equal(t, *tt.pred, got)
+			}
+			assert.Len(t, s.pending, tt.left)
+		})
+	}
+}
+
+func TestRolloverStore_DropPending(t *testing.T) {
+	rng := pkgtest.Prng(t)
+	alice := newRandomAddress(rng)
+	s := newRolloverStore()
+	s.addPending(alice, &rolloverMsg{ProposalID: client.ProposalID{1}, Predecessor: channel.ID{1}})
+	s.addPending(alice, &rolloverMsg{ProposalID: client.ProposalID{2}, Predecessor: channel.ID{2}})
+
+	s.dropPending(channel.ID{1})
+	assert.Len(t, s.pending, 1)
+	_, ok := s.takePending(client.ProposalID{2}, alice)
+	assert.True(t, ok)
+}
+
+func TestClient_HandleRollover(t *testing.T) {
+	rng := pkgtest.Prng(t)
+	tc := newTestChannel(t, rng, &Config{})
+	id, alice := tc.alice.ID(), tc.alice.Peers()[0]
+
+	tests := []struct {
+		name   string
+		sender wallet.Address
+		pred   channel.ID
+		kept   bool
+	}{
+		{"peer", alice, id, true},
+		{"unknown channel", alice, channel.ID{1}, false},
+		{"non-peer", newRandomAddress(rng), id, false},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			propID := client.ProposalID{byte(i)}
+			tc.bob.handleRollover(tt.sender, &rolloverMsg{ProposalID: propID, Predecessor: tt.pred})
+			_, ok := tc.bob.rollovers.takePending(propID, tt.sender)
+			assert.Equal(t, tt.kept, ok)
+		})
+	}
+}
+
+func TestAssetRegistry_OnFunding(t *testing.T) {
+	rng := pkgtest.Prng(t)
+	r := newAssetRegistry(ethchannel.ContractBackend{}, accounts.Account{})
+	us, peer := newRandomAddress(rng), newRandomAddress(rng)
+	req := channel.FundingReq{
+		Params: &channel.Params{Parts: []wallet.Address{peer, us}},
+		Idx:    1,
+	}
+
+	// The function of our participant runs before funding and aborts it.
+	calls, errAbort := 0, errors.New("abort")
+	r.onFunding(*peer, func() error { t.Error("function of peer called"); return nil })
+	r.onFunding(*us, func() error { calls++; return errAbort })
+	err := r.Fund(context.Background(), req)
+	assert.True(t, errors.Is(err, errAbort))
+	assert.Equal(t, 1, calls)
+	assert.NotContains(t, r.beforeFund, *us, "function must only be called once")
+
+	// Canceled functions are not called.
+	cancel := r.onFunding(*us, func() error { t.Error("canceled function called"); return nil })
+	cancel()
+	assert.NotContains(t, r.beforeFund, *us)
+}
+
+func TestPaymentChannel_Rollover(t *testing.T) {
+	rng := pkgtest.Prng(t)
+	chain, hub := newSimChain(t, rng), new(nettest.ConnHub)
+	ctx := ContextWithTimeout(int(2 * testTimeout / time.Second))
+	defer ctx.Cancel()
+	// Withdrawals of rollovers go to the on-chain accounts instead of the
+	// receivers, since the new channel is funded from there.
+	recvs := [2]*Address{{*newRandomAddress(rng)}, {*newRandomAddress(rng)}}
+	newHandlers := func() (*acceptingResponder, *acceptingUpdateHandler) {
+		return &acceptingResponder{ctx: ctx, accepted: make(chan acceptResult, 1)},
+			&acceptingUpdateHandler{t: t, accepted: make(chan *ChannelUpdate, 10)}
+	}
+	alicePH, aliceUH := newHandlers()
+	bobPH, bobUH := newHandlers()
+	alice := newSimClient(t, rng, chain, hub, &Config{WithdrawalReceiver: recvs[0]}, alicePH, aliceUH)
+	bob := newSimClient(t, rng, chain, hub, &Config{WithdrawalReceiver: recvs[1]}, bobPH, bobUH)
+
+	asset := ethwallet.Address(chain.assetHolder)
+	alloc := &Allocation{assets: []channel.Asset{&asset}, balances: [][]*big.Int{{big.NewInt(100), big.NewInt(100)}}}
+	bobAddr := &Address{*bob.onChain.Address().(*ethwallet.Address)}
+	prop, err := alice.newLedgerProposal(bobAddr, 60, alloc, channel.NoApp(), channel.NoData())
+	require.NoError(t, err)
+	pred, err := alice.proposeChannel(ctx, prop)
+	require.NoError(t, err)
+	bobPred := bobPH.awaitAccepted(t)
+	require.NoError(t, bobPred.err)
+
+	next, err := pred.Rollover(ctx, &BigInts{[]*big.Int{big.NewInt(150), big.NewInt(120)}})
+	require.NoError(t, err)
+	bobNext := bobPH.awaitAccepted(t)
+	require.NoError(t, bobNext.err)
+	predID := pred.ch.ID()
+	assert.Equal(t, predID[:], bobNext.prop.RolloverOf)
+	assert.Equal(t, next.ch.ID(), bobNext.ch.ch.ID())
+	assert.Equal(t, []*big.Int{big.NewInt(150), big.NewInt(120)}, next.ch.State().Balances[0])
+
+	// Both sides settled and closed the replaced channel.
+	assert.True(t, pred.ch.IsClosed())
+	assert.True(t, bobPred.ch.ch.IsClosed())
+	for i, recv := range recvs {
+		bal, err := chain.SimBackend.BalanceAt(ctx.ctx, common.Address(recv.addr), nil)
+		require.NoError(t, err)
+		assert.Zero(t, bal.Sign(), "receiver %d must not receive the funds", i)
+	}
+	got, err := bobNext.ch.GetPredecessor()
+	require.NoError(t, err)
+	assert.Equal(t, predID[:], got)
+}
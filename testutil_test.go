@@ -0,0 +1,142 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"context"
+	"math/big"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	ethchanneltest "perun.network/go-perun/backend/ethereum/channel/test"
+	ethwallet "perun.network/go-perun/backend/ethereum/wallet"
+	"perun.network/go-perun/backend/ethereum/wallet/keystore"
+	ethwallettest "perun.network/go-perun/backend/ethereum/wallet/test"
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/client"
+	"perun.network/go-perun/wire"
+)
+
+const testTimeout = 20 * time.Second
+
+type (
+	// acceptingProposalHandler accepts all channel proposals.
+	acceptingProposalHandler struct {
+		t    *testing.T
+		part *ethwallet.Address
+	}
+
+	// acceptingUpdateHandler accepts all updates that pass the validation.
+	acceptingUpdateHandler struct {
+		t        *testing.T
+		accepted chan *ChannelUpdate
+	}
+)
+
+func (h *acceptingProposalHandler) HandleProposal(prop client.ChannelProposal, r *client.ProposalResponder) {
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	var acc client.ChannelProposalAccept
+	switch p := prop.(type) {
+	case *client.LedgerChannelProposal:
+		acc = p.Accept(h.part, client.WithRandomNonce())
+	case *client.SubChannelProposal:
+		acc = p.Accept(client.WithRandomNonce())
+	}
+	_, err := r.Accept(ctx, acc)
+	assert.NoError(h.t, err)
+}
+
+func (h *acceptingUpdateHandler) HandleUpdate(up *ChannelUpdate, r *UpdateResponder) {
+	ctx := ContextWithTimeout(int(testTimeout / time.Second))
+	defer ctx.Cancel()
+	assert.NoError(h.t, r.Accept(ctx))
+	h.accepted <- up
+}
+
+func (h *acceptingUpdateHandler) HandlePaymentRequest(*PaymentRequest) {}
+
+// balState returns a state with version `version` and the balances `bals` of
+// a single asset.
+func balState(version uint64, bals ...int64) *channel.State {
+	s := &channel.State{Version: version, App: channel.NoApp(), Data: channel.NoData()}
+	s.Balances = channel.Balances{make([]channel.Bal, len(bals))}
+	for i, b := range bals {
+		s.Balances[0][i] = big.NewInt(b)
+	}
+	return s
+}
+
+// testChannel is a channel between the go-perun client of Alice and the
+// Client of Bob on a simulated blockchain. Bob accepts all valid updates.
+type testChannel struct {
+	alice       *client.Channel
+	aliceClient *client.Client
+	bob         *Client
+	uh          *acceptingUpdateHandler
+}
+
+// newTestClient returns a Client that only wraps `c` with the parts that are
+// needed to handle channels and updates.
+func newTestClient(c *client.Client, cfg *Config) *Client {
+	pc := &Client{
+		cfg:       cfg,
+		client:    c,
+		chans:     newChanRegistry(),
+		addrBook:  newAddressBook(nil),
+		memos:     newMemoStore(),
+		history:   newHistoryStore(),
+		rollovers: newRolloverStore(),
+		events:    newEventQueue(),
+		watchers:  newWatcherRegistry(),
+	}
+	c.OnNewChannel(pc.handleNewChannel)
+	return pc
+}
+
+// newTestChannel opens a funded channel from Alice to Bob and waits until Bob
+// registered it. Everything is closed when the test ends.
+func newTestChannel(t *testing.T, rng *rand.Rand, cfg *Config) *testChannel {
+	s := ethchanneltest.NewSetup(t, rng, 2)
+	bus := wire.NewLocalBus()
+	wallets := [2]*keystore.Wallet{}
+	clients := [2]*client.Client{}
+	for i := range clients {
+		wallets[i] = ethwallettest.NewTmpWallet()
+		c, err := client.New(s.Accs[i].Address(), bus, s.Funders[i], s.Adjs[i], wallets[i])
+		require.NoError(t, err)
+		t.Cleanup(func() { c.Close() })
+		clients[i] = c
+	}
+	bob := newTestClient(clients[1], cfg)
+	t.Cleanup(bob.events.close)
+
+	ph := &acceptingProposalHandler{t: t, part: wallets[1].NewRandomAccount(rng).Address().(*ethwallet.Address)}
+	uh := &acceptingUpdateHandler{t: t, accepted: make(chan *ChannelUpdate, 1)}
+	go bob.client.Handle(ph, &updateHandler{c: bob, h: uh})
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	initBals := &channel.Allocation{
+		Assets:   []channel.Asset{(*ethwallet.Address)(&s.Asset)},
+		Balances: channel.Balances{{big.NewInt(100), big.NewInt(100)}},
+	}
+	prop, err := client.NewLedgerChannelProposal(60, wallets[0].NewRandomAccount(rng).Address(), initBals,
+		[]wire.Address{s.Accs[0].Address(), s.Accs[1].Address()}, client.WithRandomNonce())
+	require.NoError(t, err)
+	ch, err := clients[0].ProposeChannel(ctx, prop)
+	require.NoError(t, err)
+	// Bob might finish funding after Alice and would miss earlier updates.
+	require.Eventually(t, func() bool {
+		_, ok := bob.chans.get(ch.ID())
+		return ok
+	}, testTimeout, 10*time.Millisecond)
+	return &testChannel{alice: ch, aliceClient: clients[0], bob: bob, uh: uh}
+}
@@ -0,0 +1,357 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/pkg/errors"
+
+	ethchannel "perun.network/go-perun/backend/ethereum/channel"
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/log"
+	"perun.network/go-perun/pkg/sortedkv"
+)
+
+// txPrefix is the prefix of the pending transaction table in the database.
+const txPrefix = "prnm:Tx:"
+
+// txPollInterval is the time between two checks whether a pending transaction
+// was mined.
+const txPollInterval = 2 * time.Second
+
+// Purposes of a Transaction.
+const (
+	// TxUnknown marks transactions that were sent outside of the operations
+	// below.
+	TxUnknown = iota
+	// TxDeploy marks the deployment of a contract.
+	TxDeploy
+	// TxDeposit marks the funding of a channel, including ERC20 approvals.
+	TxDeposit
+	// TxRegister marks the registration of a channel state.
+	TxRegister
+	// TxProgress marks the on-chain progression of an app channel.
+	TxProgress
+	// TxWithdraw marks the withdrawal from a channel, including its
+	// conclusion.
+	TxWithdraw
+)
+
+type (
+	// Transaction is an on-chain transaction that was sent by the Client but
+	// not mined yet.
+	Transaction struct {
+		Hash      []byte
+		Purpose   int    // See TxUnknown.
+		ChannelID []byte // Not set for TxDeploy and TxUnknown.
+		Nonce     int64
+		Timestamp int64 // Unix time in seconds when the transaction was sent.
+	}
+
+	// Transactions is a slice of Transaction's
+	Transactions struct {
+		values []Transaction
+	}
+
+	// txEntry is the database representation of a Transaction.
+	txEntry struct {
+		Hash      common.Hash `json:"hash"`
+		Purpose   int         `json:"purpose"`
+		ChannelID channel.ID  `json:"channelID"`
+		Nonce     uint64      `json:"nonce"`
+		Timestamp int64       `json:"timestamp"`
+	}
+
+	// txPurposeKey is the context key of the txPurpose of all transactions
+	// that are sent with the context.
+	txPurposeKey struct{}
+
+	txPurpose struct {
+		purpose int
+		id      channel.ID
+	}
+
+	// txRecorder records every transaction in a txStore before sending it.
+	txRecorder struct {
+		ethchannel.ContractInterface
+		store *txStore
+	}
+
+	// txBackend is the part of the ETH client that the txStore uses to await
+	// transactions.
+	txBackend interface {
+		TransactionReceipt(ctx context.Context, hash common.Hash) (*types.Receipt, error)
+		NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error)
+	}
+
+	// txStore holds all pending transactions and awaits them. Entries are
+	// kept in memory until persistence is enabled and stored in the database
+	// afterwards.
+	txStore struct {
+		mu       sync.Mutex
+		backend  txBackend
+		from     common.Address
+		txs      map[common.Hash]txEntry
+		awaiting map[common.Hash]bool
+		db       sortedkv.Database // nil until persistence is enabled.
+		ctx      context.Context   // Canceled when the Client is closed.
+	}
+)
+
+// withTxPurpose returns a context which marks all transactions that are sent
+// with it as having `purpose` for channel `id`.
+func withTxPurpose(ctx context.Context, purpose int, id channel.ID) context.Context {
+	return context.WithValue(ctx, txPurposeKey{}, txPurpose{purpose, id})
+}
+
+// SendTransaction records `tx` and sends it. The transaction is awaited in
+// the background and removed from the store once it was mined.
+func (r *txRecorder) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	p, _ := ctx.Value(txPurposeKey{}).(txPurpose)
+	e := txEntry{
+		Hash:      tx.Hash(),
+		Purpose:   p.purpose,
+		ChannelID: p.id,
+		Nonce:     tx.Nonce(),
+		Timestamp: time.Now().Unix(),
+	}
+	if err := r.store.add(e); err != nil {
+		return errors.WithMessage(err, "recording transaction")
+	}
+	if err := r.ContractInterface.SendTransaction(ctx, tx); err != nil {
+		r.store.remove(e.Hash)
+		return err
+	}
+	r.store.await(e.Hash)
+	return nil
+}
+
+func newTxStore(ctx context.Context, backend txBackend, from common.Address) *txStore {
+	return &txStore{
+		backend:  backend,
+		from:     from,
+		txs:      make(map[common.Hash]txEntry),
+		awaiting: make(map[common.Hash]bool),
+		ctx:      ctx,
+	}
+}
+
+// enablePersistence moves all entries into `db`.
+func (s *txStore) enablePersistence(db sortedkv.Database) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.db = sortedkv.NewTable(db, txPrefix)
+	for hash, e := range s.txs {
+		if err := s.persist(e); err != nil {
+			return err
+		}
+		delete(s.txs, hash)
+	}
+	return nil
+}
+
+func (s *txStore) add(e txEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.db == nil {
+		s.txs[e.Hash] = e
+		return nil
+	}
+	return s.persist(e)
+}
+
+func (s *txStore) remove(hash common.Hash) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.db == nil {
+		delete(s.txs, hash)
+		return
+	}
+	if err := s.db.Delete(hash.Hex()); err != nil {
+		log.WithField("tx", hash.Hex()).WithError(err).Warn("Removing transaction failed")
+	}
+}
+
+// list returns all pending transactions, oldest first.
+func (s *txStore) list() ([]txEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var entries []txEntry
+	if s.db == nil {
+		for _, e := range s.txs {
+			entries = append(entries, e)
+		}
+	} else {
+		it := s.db.NewIterator()
+		for it.Next() {
+			var e txEntry
+			if err := json.Unmarshal(it.ValueBytes(), &e); err != nil {
+				it.Close() // nolint:errcheck
+				return nil, errors.WithMessagef(err, "decoding transaction %s", it.Key())
+			}
+			entries = append(entries, e)
+		}
+		if err := it.Close(); err != nil {
+			return nil, errors.WithMessage(err, "closing iterator")
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Nonce < entries[j].Nonce })
+	return entries, nil
+}
+
+// persist writes `e` to the database. The caller is expected to hold the
+// mutex.
+func (s *txStore) persist(e txEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return errors.WithMessage(err, "encoding transaction")
+	}
+	return errors.WithMessage(s.db.PutBytes(e.Hash.Hex(), data), "writing transaction")
+}
+
+// awaitAll awaits all stored transactions that are not awaited yet.
+func (s *txStore) awaitAll() error {
+	entries, err := s.list()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		s.await(e.Hash)
+	}
+	return nil
+}
+
+// await waits in the background until the transaction `hash` was mined or
+// replaced and removes it from the store afterwards.
+func (s *txStore) await(hash common.Hash) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.awaiting[hash] {
+		return
+	}
+	s.awaiting[hash] = true
+	go s.poll(hash)
+}
+
+func (s *txStore) poll(hash common.Hash) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.awaiting, hash)
+		s.mu.Unlock()
+	}()
+	ticker := time.NewTicker(txPollInterval)
+	defer ticker.Stop()
+
+	for {
+		done, err := s.mined(hash)
+		if err != nil {
+			log.WithField("tx", hash.Hex()).WithError(err).Debug("Polling transaction failed")
+		} else if done {
+			s.remove(hash)
+			return
+		}
+		select {
+		case <-ticker.C:
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+// mined returns whether transaction `hash` was mined, or replaced by another
+// transaction with the same nonce.
+func (s *txStore) mined(hash common.Hash) (bool, error) {
+	receipt, err := s.backend.TransactionReceipt(s.ctx, hash)
+	if err == nil {
+		if receipt.Status == types.ReceiptStatusFailed {
+			log.WithField("tx", hash.Hex()).Warn("Transaction failed")
+		}
+		return true, nil
+	} else if !errors.Is(err, ethereum.NotFound) {
+		return false, errors.Wrap(err, "querying receipt")
+	}
+	// Without a receipt, the transaction was replaced if its nonce is used.
+	e, ok, err := s.get(hash)
+	if err != nil || !ok {
+		return !ok, err
+	}
+	nonce, err := s.backend.NonceAt(s.ctx, s.from, nil)
+	if err != nil {
+		return false, errors.Wrap(err, "querying nonce")
+	}
+	if nonce > e.Nonce {
+		log.WithField("tx", hash.Hex()).Warn("Transaction was replaced")
+		return true, nil
+	}
+	return false, nil
+}
+
+func (s *txStore) get(hash common.Hash) (e txEntry, ok bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.db == nil {
+		e, ok = s.txs[hash]
+		return e, ok, nil
+	}
+	if ok, err = s.db.Has(hash.Hex()); err != nil || !ok {
+		return e, false, errors.WithMessage(err, "reading transaction")
+	}
+	data, err := s.db.GetBytes(hash.Hex())
+	if err != nil {
+		return e, false, errors.WithMessage(err, "reading transaction")
+	}
+	return e, true, errors.WithMessage(json.Unmarshal(data, &e), "decoding transaction")
+}
+
+// GetPendingTransactions returns all transactions that the Client sent but
+// that were not mined yet, oldest first. Transactions that were pending when
+// the app was stopped are contained if persistence was enabled before they
+// were sent. They are awaited again after Restore.
+func (c *Client) GetPendingTransactions() (*Transactions, error) {
+	entries, err := c.txs.list()
+	if err != nil {
+		return nil, err
+	}
+	txs := make([]Transaction, len(entries))
+	for i, e := range entries {
+		tx := Transaction{
+			Hash:      append([]byte(nil), e.Hash[:]...),
+			Purpose:   e.Purpose,
+			Nonce:     int64(e.Nonce),
+			Timestamp: e.Timestamp,
+		}
+		if e.Purpose != TxDeploy && e.Purpose != TxUnknown {
+			tx.ChannelID = append([]byte(nil), e.ChannelID[:]...)
+		}
+		txs[i] = tx
+	}
+	return &Transactions{txs}, nil
+}
+
+// Length returns the length of the Transactions slice.
+func (ts *Transactions) Length() int {
+	return len(ts.values)
+}
+
+// Get returns the element at the given index.
+func (ts *Transactions) Get(index int) (*Transaction, error) {
+	if index < 0 || index >= len(ts.values) {
+		return nil, errors.New("get: index out of range")
+	}
+	t := ts.values[index]
+	return &t, nil
+}
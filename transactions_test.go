@@ -0,0 +1,183 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"context"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/pkg/sortedkv/memorydb"
+)
+
+// fakeTxBackend is a txBackend that knows the receipts of mined transactions
+// and the nonce of the sender.
+type fakeTxBackend struct {
+	mu       sync.Mutex
+	receipts map[common.Hash]*types.Receipt
+	nonce    uint64
+	err      error // Returned by all calls if set.
+}
+
+func (b *fakeTxBackend) TransactionReceipt(_ context.Context, hash common.Hash) (*types.Receipt, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err != nil {
+		return nil, b.err
+	}
+	if r, ok := b.receipts[hash]; ok {
+		return r, nil
+	}
+	return nil, ethereum.NotFound
+}
+
+func (b *fakeTxBackend) NonceAt(context.Context, common.Address, *big.Int) (uint64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.nonce, b.err
+}
+
+func (b *fakeTxBackend) mine(hash common.Hash, status uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.receipts[hash] = &types.Receipt{Status: status}
+}
+
+func newFakeTxBackend() *fakeTxBackend {
+	return &fakeTxBackend{receipts: make(map[common.Hash]*types.Receipt)}
+}
+
+func nonces(entries []txEntry) []uint64 {
+	ns := make([]uint64, len(entries))
+	for i, e := range entries {
+		ns[i] = e.Nonce
+	}
+	return ns
+}
+
+func TestTxStore_List(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		persist bool
+	}{{"memory", false}, {"database", true}} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTxStore(context.Background(), newFakeTxBackend(), common.Address{})
+			if tt.persist {
+				require.NoError(t, s.enablePersistence(memorydb.NewDatabase()))
+			}
+			for _, n := range []uint64{3, 1, 2} {
+				require.NoError(t, s.add(txEntry{Hash: common.Hash{byte(n)}, Nonce: n}))
+			}
+			entries, err := s.list()
+			require.NoError(t, err)
+			assert.Equal(t, []uint64{1, 2, 3}, nonces(entries))
+
+			s.remove(common.Hash{2})
+			entries, err = s.list()
+			require.NoError(t, err)
+			assert.Equal(t, []uint64{1, 3}, nonces(entries))
+
+			e, ok, err := s.get(common.Hash{3})
+			require.NoError(t, err)
+			assert.True(t, ok)
+			assert.Equal(t, uint64(3), e.Nonce)
+			_, ok, err = s.get(common.Hash{2})
+			require.NoError(t, err)
+			assert.False(t, ok)
+		})
+	}
+}
+
+func TestTxStore_Persistence(t *testing.T) {
+	before := txEntry{Hash: common.Hash{1}, Purpose: TxDeposit, ChannelID: channel.ID{1}, Nonce: 1, Timestamp: 10}
+	after := txEntry{Hash: common.Hash{2}, Purpose: TxWithdraw, ChannelID: channel.ID{1}, Nonce: 2, Timestamp: 20}
+	s := newTxStore(context.Background(), newFakeTxBackend(), common.Address{})
+	require.NoError(t, s.add(before))
+
+	db := memorydb.NewDatabase()
+	require.NoError(t, s.enablePersistence(db))
+	assert.Empty(t, s.txs, "transactions must be moved into the database")
+	require.NoError(t, s.add(after))
+
+	// A new store on the same database, as after Restore, sees all
+	// transactions.
+	restored := newTxStore(context.Background(), newFakeTxBackend(), common.Address{})
+	require.NoError(t, restored.enablePersistence(db))
+	entries, err := restored.list()
+	require.NoError(t, err)
+	assert.Equal(t, []txEntry{before, after}, entries)
+}
+
+func TestTxStore_Mined(t *testing.T) {
+	pending := txEntry{Hash: common.Hash{1}, Nonce: 5}
+	tests := []struct {
+		name    string
+		receipt *types.Receipt // nil if not mined.
+		nonce   uint64
+		stored  bool // Whether the transaction is in the store.
+		err     error
+		mined   bool
+	}{
+		{name: "successful", receipt: &types.Receipt{Status: types.ReceiptStatusSuccessful}, stored: true, mined: true},
+		{name: "failed", receipt: &types.Receipt{Status: types.ReceiptStatusFailed}, stored: true, mined: true},
+		{name: "pending", nonce: 5, stored: true},
+		{name: "replaced", nonce: 6, stored: true, mined: true},
+		{name: "removed", nonce: 5, mined: true},
+		{name: "backend error", stored: true, err: errors.New("offline")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newFakeTxBackend()
+			b.nonce, b.err = tt.nonce, tt.err
+			if tt.receipt != nil {
+				b.receipts[pending.Hash] = tt.receipt
+			}
+			s := newTxStore(context.Background(), b, common.Address{})
+			if tt.stored {
+				require.NoError(t, s.add(pending))
+			}
+
+			mined, err := s.mined(pending.Hash)
+			if tt.err != nil {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.mined, mined)
+		})
+	}
+}
+
+func TestTxStore_AwaitAll(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	b := newFakeTxBackend()
+	s := newTxStore(ctx, b, common.Address{})
+	require.NoError(t, s.enablePersistence(memorydb.NewDatabase()))
+	require.NoError(t, s.add(txEntry{Hash: common.Hash{1}, Nonce: 1}))
+	require.NoError(t, s.add(txEntry{Hash: common.Hash{2}, Nonce: 2}))
+
+	b.mine(common.Hash{1}, types.ReceiptStatusSuccessful)
+	require.NoError(t, s.awaitAll())
+	require.Eventually(t, func() bool {
+		entries, err := s.list()
+		return err == nil && len(entries) == 1
+	}, testTimeout, 10*time.Millisecond, "mined transaction must be removed")
+
+	entries, err := s.list()
+	require.NoError(t, err)
+	assert.Equal(t, common.Hash{2}, entries[0].Hash)
+}
@@ -0,0 +1,201 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"sync"
+	"time"
+
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/client"
+	"perun.network/go-perun/log"
+)
+
+const (
+	// watcherMinBackoff is the time to wait before the first restart of a
+	// failed watcher.
+	watcherMinBackoff = time.Second
+	// watcherMaxBackoff is the maximal time to wait before restarting a
+	// failed watcher. The backoff is reset once a watcher ran that long.
+	watcherMaxBackoff = 5 * time.Minute
+)
+
+type (
+	// watcherRegistry runs a watcher for every channel once managed watchers
+	// are enabled.
+	watcherRegistry struct {
+		mu       sync.Mutex
+		enabled  bool
+		h        DisputeHandler // can be nil
+		watchers map[channel.ID]*managedWatcher
+	}
+
+	// managedWatcher holds the health of the watcher of a channel.
+	managedWatcher struct {
+		mu        sync.Mutex
+		running   bool
+		restarts  int
+		lastErr   error
+		concluded bool // Whether a ConcludedEvent was seen.
+	}
+
+	// managedHandler passes all events to the disputeWatcher and marks the
+	// managedWatcher as concluded on a ConcludedEvent.
+	managedHandler struct {
+		*disputeWatcher
+		w *managedWatcher
+	}
+
+	// WatcherHealth describes the state of a managed watcher.
+	WatcherHealth struct {
+		Running   bool   // Whether the watcher currently runs.
+		Restarts  int    // Number of restarts after errors.
+		LastError string // Last error of the watcher, empty if there was none.
+	}
+)
+
+func newWatcherRegistry() *watcherRegistry {
+	return &watcherRegistry{watchers: make(map[channel.ID]*managedWatcher)}
+}
+
+// EnableWatchers lets the Client watch every open, new and restored channel.
+// Failed watchers are restarted with exponential backoff until the channel is
+// closed or concluded on-chain, see PaymentChannel.GetWatcherHealth. All adjudicator events are passed to `h`,
+// which can be nil, and to the ChannelEventListener.
+// PaymentChannel.Watch must not be called once watchers are managed by the
+// Client. Calling EnableWatchers again only replaces the handler.
+func (c *Client) EnableWatchers(h DisputeHandler) {
+	c.watchers.mu.Lock()
+	c.watchers.h = h
+	if c.watchers.enabled {
+		c.watchers.mu.Unlock()
+		return
+	}
+	c.watchers.enabled = true
+	c.watchers.mu.Unlock()
+
+	for _, ch := range c.chans.filter(func(*client.Channel) bool { return true }) {
+		c.startWatcher(ch)
+	}
+}
+
+// startWatcher starts a managed watcher for `ch` if watchers are enabled and
+// it is not watched yet. Settled channels are not watched.
+func (c *Client) startWatcher(ch *client.Channel) {
+	r := c.watchers
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.watchers[ch.ID()]; !r.enabled || ok || ch.Phase() == channel.Withdrawn {
+		return
+	}
+	w := &managedWatcher{running: true}
+	r.watchers[ch.ID()] = w
+	go c.runWatcher(ch, w)
+}
+
+// runWatcher watches `ch` and restarts the watcher after errors until the
+// channel is closed or concluded on-chain. The watcher of a concluded channel
+// would fail forever since the dispute is over.
+func (c *Client) runWatcher(ch *client.Channel, w *managedWatcher) {
+	id, backoff := ch.ID(), watcherMinBackoff
+	defer func() {
+		c.watchers.mu.Lock()
+		delete(c.watchers.watchers, id)
+		c.watchers.mu.Unlock()
+	}()
+
+	for {
+		start := time.Now()
+		h := &managedHandler{&disputeWatcher{h: c.watchers.handler(), c: c}, w}
+		err := (&PaymentChannel{ch, c}).watch(h)
+		if err == nil || ch.Ctx().Err() != nil {
+			w.update(false, nil)
+			return
+		}
+		if w.isConcluded() || ch.Phase() == channel.Withdrawn {
+			log.WithField("channel", id).WithError(err).Info("Watcher of concluded channel returned")
+			w.update(false, err)
+			return
+		}
+		log.WithField("channel", id).WithError(err).Warnf("Watcher failed, restarting in %v", backoff)
+		w.update(false, err)
+
+		if time.Since(start) > watcherMaxBackoff {
+			backoff = watcherMinBackoff
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ch.Ctx().Done():
+			return
+		}
+		if backoff *= 2; backoff > watcherMaxBackoff {
+			backoff = watcherMaxBackoff
+		}
+		w.restart()
+	}
+}
+
+// HandleAdjudicatorEvent marks the watcher as concluded on a ConcludedEvent
+// and passes `e` on.
+func (h *managedHandler) HandleAdjudicatorEvent(e channel.AdjudicatorEvent) {
+	if _, ok := e.(*channel.ConcludedEvent); ok {
+		h.w.mu.Lock()
+		h.w.concluded = true
+		h.w.mu.Unlock()
+	}
+	h.disputeWatcher.HandleAdjudicatorEvent(e)
+}
+
+func (r *watcherRegistry) handler() DisputeHandler {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.h
+}
+
+func (r *watcherRegistry) get(id channel.ID) (*managedWatcher, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	w, ok := r.watchers[id]
+	return w, ok
+}
+
+func (w *managedWatcher) update(running bool, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.running = running
+	if err != nil {
+		w.lastErr = err
+	}
+}
+
+func (w *managedWatcher) isConcluded() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.concluded
+}
+
+func (w *managedWatcher) restart() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.running = true
+	w.restarts++
+}
+
+// GetWatcherHealth returns the health of the channel's managed watcher or nil
+// if the channel is not watched by the Client, see Client.EnableWatchers.
+func (c *PaymentChannel) GetWatcherHealth() *WatcherHealth {
+	w, ok := c.c.watchers.get(c.ch.ID())
+	if !ok {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	h := &WatcherHealth{Running: w.running, Restarts: w.restarts}
+	if w.lastErr != nil {
+		h.LastError = w.lastErr.Error()
+	}
+	return h
+}
@@ -0,0 +1,52 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"perun.network/go-perun/channel"
+	pkgtest "perun.network/go-perun/pkg/test"
+)
+
+func TestClient_WatcherOfSettledChannel(t *testing.T) {
+	rng := pkgtest.Prng(t)
+	tc := newTestChannel(t, rng, &Config{})
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	// Bob settles the channel but does not close it.
+	require.NoError(t, tc.alice.UpdateBy(ctx, func(s *channel.State) error {
+		s.IsFinal = true
+		return nil
+	}))
+	<-tc.uh.accepted
+	bob, ok := tc.bob.chans.get(tc.alice.ID())
+	require.True(t, ok)
+	require.NoError(t, bob.Register(ctx))
+	require.NoError(t, bob.Settle(ctx, false))
+
+	// Settled channels are not watched.
+	tc.bob.EnableWatchers(nil)
+	assert.Nil(t, (&PaymentChannel{bob, tc.bob}).GetWatcherHealth())
+	assert.False(t, bob.IsClosed())
+}
+
+func TestManagedHandler_Concluded(t *testing.T) {
+	c := &Client{events: newEventQueue()}
+	defer c.events.close()
+	id, w := channel.ID{1}, new(managedWatcher)
+	h := &managedHandler{&disputeWatcher{c: c}, w}
+
+	h.HandleAdjudicatorEvent(&channel.RegisteredEvent{AdjudicatorEventBase: channel.AdjudicatorEventBase{IDV: id}})
+	assert.False(t, w.isConcluded())
+	h.HandleAdjudicatorEvent(&channel.ConcludedEvent{AdjudicatorEventBase: channel.AdjudicatorEventBase{IDV: id}})
+	assert.True(t, w.isConcluded(), "watcher of concluded channel must not be restarted")
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+
+	ethchannel "perun.network/go-perun/backend/ethereum/channel"
+	"perun.network/go-perun/channel"
+)
+
+// receiverAdjudicator is an ethchannel.Adjudicator that withdraws to a
+// different receiver for channels with a receiver override. Every receiver
+// gets one Adjudicator, so that the withdrawals to it are serialized by the
+// transaction mutex of that Adjudicator.
+type receiverAdjudicator struct {
+	*ethchannel.Adjudicator // withdraws to the default receiver
+
+	cb       ethchannel.ContractBackend
+	contract common.Address
+	txSender accounts.Account
+
+	mu        sync.RWMutex
+	receivers map[channel.ID]common.Address
+	adjs      map[common.Address]*ethchannel.Adjudicator // one per overriding receiver
+}
+
+func newReceiverAdjudicator(cb ethchannel.ContractBackend, contract, receiver common.Address, txSender accounts.Account) *receiverAdjudicator {
+	return &receiverAdjudicator{
+		Adjudicator: ethchannel.NewAdjudicator(cb, contract, receiver, txSender),
+		cb:          cb,
+		contract:    contract,
+		txSender:    txSender,
+		receivers:   make(map[channel.ID]common.Address),
+		adjs:        make(map[common.Address]*ethchannel.Adjudicator),
+	}
+}
+
+// Register registers the state of channel `req.Params`.
+func (a *receiverAdjudicator) Register(ctx context.Context, req channel.AdjudicatorReq) error {
+	return a.Adjudicator.Register(withTxPurpose(ctx, TxRegister, req.Params.ID()), req)
+}
+
+// Progress progresses the state of app channel `req.Params`.
+func (a *receiverAdjudicator) Progress(ctx context.Context, req channel.ProgressReq) error {
+	return a.Adjudicator.Progress(withTxPurpose(ctx, TxProgress, req.Params.ID()), req)
+}
+
+// Withdraw withdraws the funds of channel `req.Params` to its receiver.
+func (a *receiverAdjudicator) Withdraw(ctx context.Context, req channel.AdjudicatorReq, subStates channel.StateMap) error {
+	ctx = withTxPurpose(ctx, TxWithdraw, req.Params.ID())
+	a.mu.RLock()
+	adj := a.Adjudicator
+	if receiver, ok := a.receivers[req.Params.ID()]; ok {
+		adj = a.adjs[receiver]
+	}
+	a.mu.RUnlock()
+	return adj.Withdraw(ctx, req, subStates)
+}
+
+// setReceiver overrides the receiver of channel `id`. It returns a function
+// that removes the override again. The Adjudicator of a receiver is dropped
+// once no channel uses it anymore.
+func (a *receiverAdjudicator) setReceiver(id channel.ID, receiver common.Address) func() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.receivers[id] = receiver
+	if _, ok := a.adjs[receiver]; !ok {
+		adj := a.Adjudicator
+		if receiver != a.Receiver {
+			adj = ethchannel.NewAdjudicator(a.cb, a.contract, receiver, a.txSender)
+		}
+		a.adjs[receiver] = adj
+	}
+	return func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		delete(a.receivers, id)
+		for _, r := range a.receivers {
+			if r == receiver {
+				return
+			}
+		}
+		delete(a.adjs, receiver)
+	}
+}
+
+// SettleTo is like Settle but withdraws our funds to `receiver` instead of the
+// Config's WithdrawalReceiver. Sub-channels are settled into their parent, so
+// the receiver has no effect on them. A nil `receiver` behaves like Settle.
+func (c *PaymentChannel) SettleTo(ctx *Context, secondary bool, receiver *Address) error {
+	if receiver == nil {
+		return c.Settle(ctx, secondary)
+	}
+	defer c.c.adjudicator.setReceiver(c.ch.ID(), common.Address(receiver.addr))()
+	return c.Settle(ctx, secondary)
+}
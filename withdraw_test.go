@@ -0,0 +1,41 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+
+	ethchannel "perun.network/go-perun/backend/ethereum/channel"
+	"perun.network/go-perun/channel"
+)
+
+func TestReceiverAdjudicator_SetReceiver(t *testing.T) {
+	def, other := common.Address{1}, common.Address{2}
+	a := newReceiverAdjudicator(ethchannel.ContractBackend{}, common.Address{}, def, accounts.Account{})
+
+	// Channels with the same receiver share its Adjudicator.
+	remove1 := a.setReceiver(channel.ID{1}, other)
+	remove2 := a.setReceiver(channel.ID{2}, other)
+	adj := a.adjs[other]
+	assert.Equal(t, other, adj.Receiver)
+	assert.NotSame(t, a.Adjudicator, adj)
+
+	// The default receiver uses the default Adjudicator.
+	remove3 := a.setReceiver(channel.ID{3}, def)
+	assert.Same(t, a.Adjudicator, a.adjs[def])
+
+	// The Adjudicator of a receiver is kept while a channel uses it.
+	remove1()
+	assert.Same(t, adj, a.adjs[other])
+	remove2()
+	remove3()
+	assert.Empty(t, a.adjs)
+	assert.Empty(t, a.receivers)
+}